@@ -0,0 +1,124 @@
+package carbon20
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var errKeepAndDrop = errors.New("can't both keep and drop tags")
+
+var errFmtNodeOutOfRange = "node %d out of range for %q"
+var errFmtAggBySep = "can't aggregate across tag %q: keys listed in agg_by can't contain " + aggBySep
+
+// AggByTagKey is the key of the meta tag that lists the tags an aggregate was computed across
+const AggByTagKey = "agg_by"
+
+// aggBySep separates the keys in the agg_by tag.
+// unlike _ and -, it's not used in keys, and it doesn't clash with the __ of timespecs (see StatTransform).
+// AggregateKey refuses keys that contain it, so the tag can always be split back up, see AggregatedBy.
+const aggBySep = "+"
+
+// aggPreserved lists the tags that aggregation never removes: they describe the values, not the series
+var aggPreserved = map[string]bool{
+	"unit":  true,
+	"mtype": true,
+	"le":    true,
+}
+
+// Aggregation describes which series a cross-series aggregation (such as a sum over hosts) combines.
+// For metrics 2.0, set either Keep or Drop. For legacy metrics, set Nodes.
+type Aggregation struct {
+	Keep  []string // tags to keep. all other tags are aggregated across
+	Drop  []string // tags to aggregate across
+	Nodes []int    // positions of the legacy nodes to aggregate across. negative positions count from the end
+}
+
+// AggregateKey returns the name of the series that aggregates in across the tags or nodes given by a.
+// For metrics 2.0 the aggregated tags are removed and listed in an agg_by tag, e.g. agg_by=host+dc.
+// unit, mtype and le tags, as well as nodes that are not tags, are always preserved.
+// For legacy metrics, the aggregated nodes are replaced with a * wildcard.
+// Use the output as input for an operation such as Sum to name the aggregate.
+func AggregateKey(in string, a Aggregation) (string, error) {
+	if a.Keep != nil && a.Drop != nil {
+		return "", errKeepAndDrop
+	}
+	ver := GetVersion(in)
+	if ver == Legacy {
+		return aggregateKeyLegacy(in, a.Nodes)
+	}
+	sep := tagSep(ver)
+	contains := func(list []string, s string) bool {
+		for _, e := range list {
+			if e == s {
+				return true
+			}
+		}
+		return false
+	}
+
+	var aggBy, dropped []string
+	var out []string
+	for _, node := range strings.Split(in, ".") {
+		k, v, ok := splitTag(node, sep)
+		switch {
+		case !ok || aggPreserved[k]:
+			out = append(out, node)
+		case k == AggByTagKey:
+			// aggregating an aggregate: keep what it was aggregated across
+			for _, by := range splitAggBy(v) {
+				if !contains(aggBy, by) {
+					aggBy = append(aggBy, by)
+				}
+			}
+		case a.Keep != nil && !contains(a.Keep, k), contains(a.Drop, k):
+			if strings.Contains(k, aggBySep) {
+				return "", fmt.Errorf(errFmtAggBySep, k)
+			}
+			dropped = append(dropped, k)
+		default:
+			out = append(out, node)
+		}
+	}
+	for _, k := range dropped {
+		if !contains(aggBy, k) {
+			aggBy = append(aggBy, k)
+		}
+	}
+	if len(aggBy) > 0 {
+		out = append(out, AggByTagKey+sep+strings.Join(aggBy, aggBySep))
+	}
+	return strings.Join(out, "."), nil
+}
+
+// AggregatedBy returns the keys of the tags that in was aggregated across, as listed in its agg_by tag.
+// it returns nil if in is not an aggregate, see AggregateKey.
+func AggregatedBy(in string) []string {
+	v, ok := GetTag(in, AggByTagKey)
+	if !ok {
+		return nil
+	}
+	return splitAggBy(v)
+}
+
+func splitAggBy(v string) []string {
+	if v == "" {
+		return nil
+	}
+	return strings.Split(v, aggBySep)
+}
+
+func aggregateKeyLegacy(in string, positions []int) (string, error) {
+	nodes := strings.Split(in, ".")
+	for _, pos := range positions {
+		i := pos
+		if i < 0 {
+			i += len(nodes)
+		}
+		if i < 0 || i >= len(nodes) {
+			return "", fmt.Errorf(errFmtNodeOutOfRange, pos, in)
+		}
+		nodes[i] = "*"
+	}
+	return strings.Join(nodes, "."), nil
+}
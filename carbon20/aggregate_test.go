@@ -0,0 +1,81 @@
+package carbon20
+
+import (
+	"testing"
+
+	"github.com/bmizerany/assert"
+)
+
+func TestAggregateKey(t *testing.T) {
+	cases := []struct {
+		in  string
+		a   Aggregation
+		out string
+	}{
+		{"what=cpu.host=a.dc=ams.unit=Jiff.mtype=gauge", Aggregation{Drop: []string{"host"}}, "what=cpu.dc=ams.unit=Jiff.mtype=gauge.agg_by=host"},
+		{"what=cpu.host=a.dc=ams.unit=Jiff.mtype=gauge", Aggregation{Keep: []string{"what"}}, "what=cpu.unit=Jiff.mtype=gauge.agg_by=host+dc"},
+		{"what=cpu.host=a.unit=Jiff.mtype=gauge", Aggregation{Drop: []string{"unit", "mtype", "dc"}}, "what=cpu.host=a.unit=Jiff.mtype=gauge"},
+		{"what=lat.host=a.le=0_5.unit=ms", Aggregation{Keep: []string{}}, "le=0_5.unit=ms.agg_by=what+host"},
+		{"what=cpu.dc=ams.unit=Jiff.agg_by=host", Aggregation{Drop: []string{"dc"}}, "what=cpu.unit=Jiff.agg_by=host+dc"},
+		{"what_is_cpu.host_is_a.unit_is_Jiff", Aggregation{Drop: []string{"host"}}, "what_is_cpu.unit_is_Jiff.agg_by_is_host"},
+		{"servers.web1.cpu.idle", Aggregation{Nodes: []int{1}}, "servers.*.cpu.idle"},
+		{"servers.web1.cpu.idle", Aggregation{Nodes: []int{1, -1}}, "servers.*.cpu.*"},
+		{"servers.web1.cpu.idle", Aggregation{}, "servers.web1.cpu.idle"},
+	}
+	for i, c := range cases {
+		out, err := AggregateKey(c.in, c.a)
+		if err != nil {
+			t.Fatalf("case %d: %s", i, err)
+		}
+		assert.Equal(t, c.out, out)
+	}
+
+	// the name of a sum over hosts
+	key, err := AggregateKey("what=cpu.host=a.unit=Jiff.mtype=gauge", Aggregation{Drop: []string{"host"}})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "what=cpu.unit=Jiff.mtype=gauge.agg_by=host.stat=sum", must(Sum(key, Prefixes{}, "", Timespec{})))
+}
+
+func TestAggregatedBy(t *testing.T) {
+	cases := []struct {
+		in   string
+		a    Aggregation
+		keys []string
+	}{
+		{"what=cpu.host=a.dc=ams.unit=Jiff", Aggregation{Drop: []string{"host", "dc"}}, []string{"host", "dc"}},
+		{"what=cpu.my__host=a.dc=ams.unit=Jiff", Aggregation{Drop: []string{"my__host", "dc"}}, []string{"my__host", "dc"}},
+		{"what_is_cpu.my__host_is_a.dc_is_ams.unit_is_Jiff", Aggregation{Keep: []string{"what"}}, []string{"my__host", "dc"}},
+		{"what=cpu.host-name=a.unit=Jiff.agg_by=dc_id", Aggregation{Drop: []string{"host-name"}}, []string{"dc_id", "host-name"}},
+		{"what=cpu.host=a.unit=Jiff", Aggregation{}, nil},
+	}
+	for i, c := range cases {
+		key, err := AggregateKey(c.in, c.a)
+		if err != nil {
+			t.Fatalf("case %d: %s", i, err)
+		}
+		assert.Equal(t, c.keys, AggregatedBy(key))
+		// stats use __ for timespecs, which must not get in the way
+		stat := must(Max(key, Prefixes{}, "", Timespec{1, "m"}))
+		assert.Equal(t, c.keys, AggregatedBy(stat))
+	}
+	assert.Equal(t, []string(nil), AggregatedBy("foo.bar"))
+}
+
+func TestAggregateKeyErrors(t *testing.T) {
+	_, err := AggregateKey("what=cpu.host=a", Aggregation{Keep: []string{"what"}, Drop: []string{"host"}})
+	assert.Equal(t, errKeepAndDrop, err)
+	_, err = AggregateKey("servers.web1", Aggregation{Nodes: []int{2}})
+	assert.Equal(t, `node 2 out of range for "servers.web1"`, err.Error())
+	_, err = AggregateKey("servers.web1", Aggregation{Nodes: []int{-3}})
+	assert.NotEqual(t, nil, err)
+	_, err = AggregateKey("what=cpu.a+b=c", Aggregation{Drop: []string{"a+b"}})
+	assert.Equal(t, `can't aggregate across tag "a+b": keys listed in agg_by can't contain +`, err.Error())
+}
+
+func TestAggregateKeyValid(t *testing.T) {
+	key, err := AggregateKey("what=cpu.host=a.dc=ams.unit=Jiff.mtype=gauge", Aggregation{Keep: []string{"what"}})
+	assert.Equal(t, nil, err)
+	for _, level := range []ValidationLevelM20{StrictM20, MediumM20, UTF8M20} {
+		assert.Equal(t, nil, ValidateKeyM20(key, level))
+	}
+}
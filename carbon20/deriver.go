@@ -0,0 +1,164 @@
+package carbon20
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// CounterWrap decides how a Deriver interprets a counter that went down
+type CounterWrap int
+
+const (
+	WrapNone CounterWrap = iota // the counter was reset to zero
+	Wrap32                      // the counter wrapped around at 2^32, if it was in the upper half of its range. otherwise it was reset
+	Wrap64                      // the counter wrapped around at 2^64, if it was in the upper half of its range. otherwise it was reset
+	WrapAuto                    // like Wrap32 if the previous value fits in 32 bits, like Wrap64 otherwise
+)
+
+// DeriverOptions configures a Deriver
+type DeriverOptions struct {
+	Prefixes Prefixes // prefixes for the names of the rate points, as for DeriveCount
+	M1Legacy bool     // as for DeriveCount
+	Wrap     CounterWrap
+	MaxGap   time.Duration // max time between two points to derive a rate from. 0 means no limit
+}
+
+// derivState is what a Deriver remembers about a series
+type derivState struct {
+	name  string // name of the rate points
+	ts    int64  // in nanoseconds
+	value Value
+}
+
+// Deriver computes the rate per second of counters, from consecutive points of each series.
+// It is safe for concurrent use.
+type Deriver struct {
+	opts   DeriverOptions
+	t      Transform
+	mu     sync.Mutex
+	series map[string]derivState
+}
+
+// NewDeriver returns a deriver with the given options
+func NewDeriver(opts DeriverOptions) *Deriver {
+	t := DeriveCountTransform(opts.M1Legacy)
+	t.From = []MType{MTypeCounter}
+	return &Deriver{
+		opts:   opts,
+		t:      t,
+		series: make(map[string]derivState),
+	}
+}
+
+// Add adds a point of the counter with the given key, and returns the rate point, named like DeriveCount does.
+// ts has precision p, and the rate point gets ts as is.
+// ok is false if no rate can be computed yet: for the first point of a series, for a point that is not newer than
+// the previous one (which is dropped), and for a point that comes more than MaxGap after the previous one.
+// integer values are subtracted exactly, so counters near 2^64 don't lose increments.
+// metrics 2.0 keys must have mtype=counter.
+func (d *Deriver) Add(key string, v Value, ts int64, p Precision) (pt Point, ok bool, err error) {
+	ns, err := tsNanos(ts, p)
+	if err != nil {
+		return Point{}, false, err
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	prev, seen := d.series[key]
+	if !seen {
+		prev.name, err = d.t.Apply(key, d.opts.Prefixes)
+		if err != nil {
+			return Point{}, false, err
+		}
+		d.series[key] = derivState{prev.name, ns, v}
+		return Point{}, false, nil
+	}
+	if ns <= prev.ts {
+		return Point{}, false, nil
+	}
+	d.series[key] = derivState{prev.name, ns, v}
+	gap := time.Duration(ns - prev.ts)
+	if d.opts.MaxGap != 0 && gap > d.opts.MaxGap {
+		return Point{}, false, nil
+	}
+	return Point{prev.name, d.delta(prev.value, v) / gap.Seconds(), ts}, true, nil
+}
+
+// delta returns how much the counter increased from prev to cur, taking resets and wraps into account.
+// if both values are non-negative integers, the math is done in uint64.
+func (d *Deriver) delta(prev, cur Value) float64 {
+	p, pOk := prev.Uint64()
+	c, cOk := cur.Uint64()
+	if !pOk || !cOk {
+		return d.deltaFloat(prev.Float64(), cur.Float64())
+	}
+	if c >= p {
+		return float64(c - p)
+	}
+	switch d.wrap(p <= math.MaxUint32) {
+	case Wrap32:
+		if p >= 1<<31 && p <= math.MaxUint32 {
+			return float64(1<<32 - p + c)
+		}
+	case Wrap64:
+		if p >= 1<<63 {
+			return float64(math.MaxUint64 - p + c + 1)
+		}
+	}
+	return float64(c)
+}
+
+// deltaFloat is delta for float values
+func (d *Deriver) deltaFloat(prev, cur float64) float64 {
+	if cur >= prev {
+		return cur - prev
+	}
+	var limit float64
+	switch d.wrap(prev <= math.MaxUint32) {
+	case Wrap32:
+		limit = 1 << 32
+	case Wrap64:
+		limit = 1 << 64
+	}
+	if limit == 0 || prev < limit/2 || prev >= limit {
+		return cur
+	}
+	return limit - prev + cur
+}
+
+// wrap returns the wrap to use for a counter that went down, resolving WrapAuto
+func (d *Deriver) wrap(fits32 bool) CounterWrap {
+	if d.opts.Wrap != WrapAuto {
+		return d.opts.Wrap
+	}
+	if fits32 {
+		return Wrap32
+	}
+	return Wrap64
+}
+
+// Forget removes the state of the series with the given key
+func (d *Deriver) Forget(key string) {
+	d.mu.Lock()
+	delete(d.series, key)
+	d.mu.Unlock()
+}
+
+// Prune removes the state of all series whose last point is older than ts, which has precision p,
+// and returns how many were removed
+func (d *Deriver) Prune(ts int64, p Precision) (int, error) {
+	ts, err := tsNanos(ts, p)
+	if err != nil {
+		return 0, err
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	n := 0
+	for key, s := range d.series {
+		if s.ts < ts {
+			delete(d.series, key)
+			n++
+		}
+	}
+	return n, nil
+}
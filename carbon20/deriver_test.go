@@ -0,0 +1,139 @@
+package carbon20
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/bmizerany/assert"
+)
+
+type derivInput struct {
+	ts    int64
+	value float64
+	ok    bool
+	rate  float64
+}
+
+func testDeriver(t *testing.T, d *Deriver, key, name string, inputs []derivInput) {
+	for i, in := range inputs {
+		p, ok, err := d.Add(key, FloatValue(in.value), in.ts, PrecisionSecond)
+		if err != nil {
+			t.Fatalf("point %d: %s", i, err)
+		}
+		if ok != in.ok {
+			t.Fatalf("point %d: expected ok=%t, got %t", i, in.ok, ok)
+		}
+		if ok {
+			assert.Equal(t, Point{name, in.rate, in.ts}, p)
+		}
+	}
+}
+
+func TestDeriver(t *testing.T) {
+	d := NewDeriver(DeriverOptions{MaxGap: time.Minute})
+	testDeriver(t, d, "what=rx.unit=B.mtype=counter", "what=rx.unit=Bps.mtype=rate", []derivInput{
+		{10, 100, false, 0},
+		{20, 200, true, 10},
+		{30, 500, true, 30},
+		{25, 400, false, 0},  // out of order
+		{30, 500, false, 0},  // duplicate
+		{40, 100, true, 10},  // reset
+		{200, 300, false, 0}, // gap
+		{210, 400, true, 10},
+	})
+	testDeriver(t, d, "foo.bar", "foo.bar.rate", []derivInput{
+		{10, 1, false, 0},
+		{12, 5, true, 2},
+	})
+}
+
+func TestDeriverPrecision(t *testing.T) {
+	d := NewDeriver(DeriverOptions{})
+	_, ok, err := d.Add("foo", IntValue(100), 10000, PrecisionMilli)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, false, ok)
+	p, ok, err := d.Add("foo", IntValue(150), 10500, PrecisionMilli)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, ok)
+	assert.Equal(t, Point{"foo.rate", 100, 10500}, p)
+	p, ok, err = d.Add("foo", IntValue(250), 11, PrecisionSecond)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, ok)
+	assert.Equal(t, Point{"foo.rate", 200, 11}, p)
+
+	_, _, err = d.Add("foo", IntValue(1), 1, Precision(7))
+	assert.Equal(t, "unknown precision 7", err.Error())
+	_, _, err = d.Add("foo", IntValue(1), math.MaxInt64/1000, PrecisionMilli)
+	assert.Equal(t, errTsOverflow, err)
+}
+
+func TestDeriverTimestamps(t *testing.T) {
+	d := NewDeriver(DeriverOptions{})
+	testDeriver(t, d, "foo.bar", "foo.bar.rate", []derivInput{
+		{-20, 1, false, 0},
+		{-10, 11, true, 1},
+		{10, 31, true, 1},
+	})
+	// past 2106, second timestamps don't fit in 32 bits
+	d = NewDeriver(DeriverOptions{})
+	testDeriver(t, d, "foo.bar", "foo.bar.rate", []derivInput{
+		{5000000000, 1, false, 0},
+		{5000000010, 21, true, 2},
+	})
+}
+
+func TestDeriverWrap(t *testing.T) {
+	cases := []struct {
+		wrap      CounterWrap
+		prev, cur Value
+		delta     float64
+	}{
+		{WrapNone, IntValue(4294967000), IntValue(100), 100},
+		{Wrap32, IntValue(4294967000), IntValue(100), 396},
+		{Wrap32, IntValue(1000), IntValue(100), 100},
+		{WrapAuto, IntValue(4294967000), IntValue(100), 396},
+		{Wrap64, IntValue(4294967000), IntValue(100), 100},
+		{Wrap64, UintValue(1 << 63), IntValue(100), float64(uint64(1<<63 + 100))},
+		{WrapAuto, UintValue(1 << 63), IntValue(100), float64(uint64(1<<63 + 100))},
+		// near 2^64 and 2^63, float64 can't represent the increments
+		{Wrap64, UintValue(math.MaxUint64 - 99), IntValue(100), 200},
+		{WrapAuto, UintValue(math.MaxUint64 - 99), IntValue(100), 200},
+		{WrapNone, UintValue(1<<63 + 1000), UintValue(1<<63 + 1500), 500},
+		{WrapNone, IntValue(1<<62 + 1), IntValue(1<<62 + 2), 1},
+		// floats and negative values use float math
+		{Wrap32, FloatValue(4294967000), FloatValue(100), 396},
+		{WrapNone, IntValue(-10), IntValue(5), 15},
+	}
+	for i, c := range cases {
+		d := NewDeriver(DeriverOptions{Wrap: c.wrap})
+		d.Add("foo", c.prev, 10, PrecisionSecond)
+		p, ok, err := d.Add("foo", c.cur, 20, PrecisionSecond)
+		if err != nil || !ok {
+			t.Fatalf("case %d: %v", i, err)
+		}
+		if p.Value != c.delta/10 {
+			t.Fatalf("case %d: expected rate %v, got %v", i, c.delta/10, p.Value)
+		}
+	}
+}
+
+func TestDeriverOptions(t *testing.T) {
+	p, err := NewPrefixes("derived")
+	assert.Equal(t, nil, err)
+	d := NewDeriver(DeriverOptions{Prefixes: p, M1Legacy: true})
+	testDeriver(t, d, "foo.bar", "derived.foo.bar", []derivInput{
+		{10, 1, false, 0},
+		{12, 5, true, 2},
+	})
+
+	_, _, err = d.Add("what=req.unit=Req.mtype=count", IntValue(1), 10, PrecisionSecond)
+	assert.Equal(t, "derive_count can't be applied to mtype=count", err.Error())
+
+	n, err := d.Prune(20000, PrecisionMilli)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 1, n)
+	testDeriver(t, d, "foo.bar", "derived.foo.bar", []derivInput{{30, 1, false, 0}})
+	d.Forget("foo.bar")
+	testDeriver(t, d, "foo.bar", "derived.foo.bar", []derivInput{{40, 1, false, 0}})
+}
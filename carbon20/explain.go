@@ -0,0 +1,177 @@
+package carbon20
+
+import "strings"
+
+// Explanation describes how a derived metric was likely produced
+type Explanation struct {
+	Op         string // name of the operation, as in DefaultRegistry. e.g. "max" or "derive_count"
+	Source     string // the likely input metric of the operation
+	Percentile string // for stats only
+	Timespec   string // for stats only
+}
+
+// legacyStats maps the legacy stat names that simpleStat writes to the metrics 2.0 stat names
+var legacyStats = map[string]string{
+	"upper":  "max",
+	"lower":  "min",
+	"mean":   "mean",
+	"sum":    "sum",
+	"median": "median",
+	"std":    "std",
+}
+
+// legacyOps maps the legacy suffixes of the operations in manipulate.go to the operation names.
+// .count is written by Count, CountMetric and CountPckt. we assume the latter, like statsd.
+var legacyOps = map[string]string{
+	"count_ps": "rate_pckt",
+	"count":    "count_pckt",
+	"rate":     "derive_count",
+	"counter":  "counter",
+
+	"bucket_count": "histogram_count",
+}
+
+// Explain returns the likely source metric of a derived metric, and the operation that produced it,
+// based on the conventions used by the functions in manipulate.go:
+// * a stat tag (or legacy stat node such as upper_90) for stats
+// * unit=Pckt or Pcktps with orig_unit, pckt_type and direction tags for CountPckt and RatePckt
+// * unit=Metric with an orig_unit tag for CountMetric
+// * mtype=rate with a unit with the ps suffix for DeriveCount (the source is assumed to be a count)
+// * an le tag (or .bucket.le_<bound> nodes) for HistogramBucket, and stat=count for HistogramCount
+// for nested stats such as stat=max_of_mean, the outer stat is explained and the source keeps the inner one.
+// ok is false if the metric doesn't look derived. prefixes are not removed from the source.
+// for CountPckt, CountMetric and RatePckt, the original mtype can't be recovered, so it is kept as is.
+// HistogramSum can't be told apart from Sum, so its output is explained as the latter.
+func Explain(in string) (e Explanation, ok bool) {
+	ver := GetVersion(in)
+	if ver == Legacy {
+		return explainLegacy(in)
+	}
+	sep := tagSep(ver)
+	nodes := strings.Split(in, ".")
+	find := func(key string) (int, string) {
+		for i := len(nodes) - 1; i >= 0; i-- {
+			k, v, ok := splitTag(nodes[i], sep)
+			if ok && k == key {
+				return i, v
+			}
+		}
+		return -1, ""
+	}
+	without := func(keys ...string) []string {
+		var out []string
+		for _, node := range nodes {
+			k, _, _ := splitTag(node, sep)
+			keep := true
+			for _, key := range keys {
+				if k == key {
+					keep = false
+				}
+			}
+			if keep {
+				out = append(out, node)
+			}
+		}
+		return out
+	}
+
+	// whichever of the le and stat tags comes last was likely added last
+	i, v := find("stat")
+	if li, le := find("le"); li > i {
+		if _, err := ParseBound(le); err == nil {
+			return Explanation{Op: "histogram_bucket", Source: strings.Join(without("le"), ".")}, true
+		}
+	}
+	if i >= 0 {
+		stat, pct, ts, inner := parseStatValue(v)
+		if stat == "count" {
+			stat = "histogram_count"
+		}
+		src := append(append([]string(nil), nodes[:i]...), nodes[i+1:]...)
+		if inner != "" {
+			nodes[i] = "stat" + sep + inner
+			src = nodes
+		}
+		return Explanation{stat, strings.Join(src, "."), pct, ts}, true
+	}
+
+	ui, unit := find("unit")
+	_, mtype := find("mtype")
+	oi, orig := find("orig_unit")
+	if ui >= 0 && oi >= 0 {
+		var op string
+		switch unit {
+		case "Pckt":
+			op = "count_pckt"
+		case "Pcktps":
+			op = "rate_pckt"
+		case "Metric":
+			op = "count_metric"
+		}
+		pi, pcktType := find("pckt_type")
+		di, direction := find("direction")
+		isPckt := pi >= 0 && pcktType == "sent" && di >= 0 && direction == "in"
+		if op != "" && isPckt == (op != "count_metric") {
+			nodes[ui] = "unit" + sep + orig
+			return Explanation{Op: op, Source: strings.Join(without("orig_unit", "pckt_type", "direction"), ".")}, true
+		}
+	}
+
+	if ui >= 0 && mtype == "rate" {
+		if u, err := ParseUnit(unit); err == nil && u.PerSecond {
+			u.PerSecond = false
+			nodes[ui] = "unit" + sep + u.String()
+			mi, _ := find("mtype")
+			nodes[mi] = "mtype" + sep + "count"
+			return Explanation{Op: "derive_count", Source: strings.Join(nodes, ".")}, true
+		}
+	}
+	return Explanation{}, false
+}
+
+// parseStatValue parses the value of a stat tag, e.g. max_90__1m.
+// for nested stats such as max__1h_of_mean__1m, the outer stat is parsed and the inner one returned as is.
+func parseStatValue(v string) (stat, percentile, timespec, inner string) {
+	v, inner = splitNested(v)
+	if i := strings.Index(v, "__"); i >= 0 {
+		v, timespec = v[:i], v[i+2:]
+	}
+	if i := strings.IndexByte(v, '_'); i >= 0 && isPercentile(v[i+1:]) {
+		v, percentile = v[:i], v[i+1:]
+	}
+	return v, percentile, timespec, inner
+}
+
+// isPercentile returns whether s looks like a percentile specifier, such as 90 or 99_9
+func isPercentile(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		if !(c >= '0' && c <= '9') && c != '_' {
+			return false
+		}
+	}
+	return true
+}
+
+func explainLegacy(in string) (Explanation, bool) {
+	i := strings.LastIndexByte(in, '.')
+	if i <= 0 {
+		return Explanation{}, false
+	}
+	src, last := in[:i], in[i+1:]
+	if op, ok := legacyOps[last]; ok {
+		return Explanation{Op: op, Source: src}, true
+	}
+	if strings.HasPrefix(last, "le_") && strings.HasSuffix(src, ".bucket") {
+		if _, err := ParseBound(last[len("le_"):]); err == nil {
+			return Explanation{Op: "histogram_bucket", Source: strings.TrimSuffix(src, ".bucket")}, true
+		}
+	}
+	stat, pct, ts, _ := parseStatValue(last)
+	if op, ok := legacyStats[stat]; ok {
+		return Explanation{op, src, pct, ts}, true
+	}
+	return Explanation{}, false
+}
@@ -0,0 +1,76 @@
+package carbon20
+
+import (
+	"testing"
+
+	"github.com/bmizerany/assert"
+)
+
+func TestExplain(t *testing.T) {
+	cases := []struct {
+		in string
+		e  Explanation
+		ok bool
+	}{
+		{"foo.unit=Bps.mtype=rate", Explanation{Op: "derive_count", Source: "foo.unit=B.mtype=count"}, true},
+		{"foo_is_bar.unit_is_MiBps.mtype_is_rate", Explanation{Op: "derive_count", Source: "foo_is_bar.unit_is_MiB.mtype_is_count"}, true},
+		{"foo.bar.upper_90", Explanation{"max", "foo.bar", "90", ""}, true},
+		{"foo.bar.lower__1m", Explanation{"min", "foo.bar", "", "1m"}, true},
+		{"foo.bar.median_99_9__1h", Explanation{"median", "foo.bar", "99_9", "1h"}, true},
+		{"foo.bar.count_ps", Explanation{Op: "rate_pckt", Source: "foo.bar"}, true},
+		{"foo.bar.count", Explanation{Op: "count_pckt", Source: "foo.bar"}, true},
+		{"foo.bar.rate", Explanation{Op: "derive_count", Source: "foo.bar"}, true},
+		{"foo.bar.counter", Explanation{Op: "counter", Source: "foo.bar"}, true},
+		{"what=lat.unit=ms.mtype=gauge.stat=max_90__1m", Explanation{"max", "what=lat.unit=ms.mtype=gauge", "90", "1m"}, true},
+		{"what_is_lat.unit_is_ms.stat_is_std.mtype_is_gauge", Explanation{"std", "what_is_lat.unit_is_ms.mtype_is_gauge", "", ""}, true},
+		{"foo.bar.unit=Pckt.baz.orig_unit=yes.pckt_type=sent.direction=in", Explanation{Op: "count_pckt", Source: "foo.bar.unit=yes.baz"}, true},
+		{"mtype=rate.foo.unit=Pcktps.bar.orig_unit=ok.pckt_type=sent.direction=in", Explanation{Op: "rate_pckt", Source: "mtype=rate.foo.unit=ok.bar"}, true},
+		{"what=rx.unit=Metric.mtype=count.orig_unit=B", Explanation{Op: "count_metric", Source: "what=rx.unit=B.mtype=count"}, true},
+		{"what=rx.unit=B.mtype=gauge", Explanation{}, false},
+		{"what=rx.unit=Bps.mtype=gauge", Explanation{}, false},
+		{"what=lat.unit=ms.stat=max__1h_of_mean__1m", Explanation{"max", "what=lat.unit=ms.stat=mean__1m", "", "1h"}, true},
+		{"foo.bar.mean__1m.upper__1h", Explanation{"max", "foo.bar.mean__1m", "", "1h"}, true},
+		{"what=lat.unit=ms.mtype=count.le=0_5", Explanation{Op: "histogram_bucket", Source: "what=lat.unit=ms.mtype=count"}, true},
+		{"what=lat.unit=ms.stat=max.le=inf", Explanation{Op: "histogram_bucket", Source: "what=lat.unit=ms.stat=max"}, true},
+		{"what=lat.unit=ms.le=1.stat=max", Explanation{"max", "what=lat.unit=ms.le=1", "", ""}, true},
+		{"what=rx.unit=B.mtype=count.stat=count", Explanation{Op: "histogram_count", Source: "what=rx.unit=B.mtype=count"}, true},
+		{"foo.lat.bucket.le_0_5", Explanation{Op: "histogram_bucket", Source: "foo.lat"}, true},
+		{"foo.lat.bucket_count", Explanation{Op: "histogram_count", Source: "foo.lat"}, true},
+		{"foo.bar", Explanation{}, false},
+		{"foo", Explanation{}, false},
+	}
+	for i, c := range cases {
+		e, ok := Explain(c.in)
+		if ok != c.ok {
+			t.Fatalf("case %d: Explain(%q): expected ok=%t, got %t", i, c.in, c.ok, ok)
+		}
+		assert.Equal(t, c.e, e)
+	}
+}
+
+// explaining the output of an operation should yield the operation and its input
+func TestExplainRoundTrip(t *testing.T) {
+	for _, in := range []string{"foo.bar", "what=rx.host=a.unit=B.mtype=count", "what_is_rx.unit_is_B.mtype_is_count"} {
+		for _, op := range []string{"derive_count", "max", "min", "mean", "sum", "median", "std", "count_pckt", "rate_pckt", "histogram_bucket", "histogram_count"} {
+			p := Params{}
+			switch op {
+			case "derive_count", "count_pckt", "rate_pckt", "histogram_count":
+			case "histogram_bucket":
+				p.UpperBound = 0.5
+			default:
+				p.Percentile, p.Timespec = "90", Timespec{1, "m"}
+			}
+			out, err := DefaultRegistry.Apply(op, in, p)
+			assert.Equal(t, nil, err)
+			// RatePckt replaces the mtype, which can't be recovered
+			src := in
+			if op == "rate_pckt" {
+				src, _ = replaceTag(in, "mtype", "rate")
+			}
+			e, ok := Explain(out)
+			if !ok || e.Op != op || e.Source != src || e.Percentile != p.Percentile || e.Timespec != p.Timespec.String() {
+				t.Fatalf("Explain(%s(%q) = %q) = %+v, %t", op, in, out, e, ok)
+			}
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package carbon20
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+var errNoLe = errors.New("histogram bucket must have an le label")
+
+var errFmtInvalidBound = "invalid bucket bound %q"
+var errFmtUnknownPromType = "unknown prometheus metric type %q"
+
+// FormatBound formats the upper bound of a histogram bucket, for use in metric names.
+// dots become underscores and infinities become inf and -inf, e.g. 0.5 -> 0_5. the shortest exact representation is used,
+// so a given bound always yields the same name.
+func FormatBound(b float64) string {
+	switch {
+	case math.IsInf(b, 1):
+		return "inf"
+	case math.IsInf(b, -1):
+		return "-inf"
+	}
+	return strings.Replace(strconv.FormatFloat(b, 'f', -1, 64), ".", "_", 1)
+}
+
+// ParseBound is the inverse of FormatBound
+func ParseBound(s string) (float64, error) {
+	switch s {
+	case "inf":
+		return math.Inf(1), nil
+	case "-inf":
+		return math.Inf(-1), nil
+	}
+	f := strings.Replace(s, "_", ".", 1)
+	b, err := strconv.ParseFloat(f, 64)
+	if err != nil || strings.ContainsAny(s, ".eE") || strings.Contains(f, "_") || math.IsInf(b, 0) || math.IsNaN(b) {
+		return 0, fmt.Errorf(errFmtInvalidBound, s)
+	}
+	return b, nil
+}
+
+// HistogramBucketTransform counts the values up to and including upperBound.
+// metrics 2.0 get an le tag, legacy metrics get .bucket.le_<bound> nodes.
+func HistogramBucketTransform(upperBound float64) Transform {
+	b := FormatBound(upperBound)
+	return newTransform("histogram_bucket", ".bucket.le_"+b, SetTag("le", b))
+}
+
+// HistogramCountTransform counts the values of a histogram.
+// metrics 2.0 get stat=count, legacy metrics get a .bucket_count node, so they don't look like the output of CountPckt.
+func HistogramCountTransform() Transform {
+	return newTransform("histogram_count", ".bucket_count", AddStat("count", StatNest))
+}
+
+// HistogramSumTransform sums the values of a histogram, like Sum does.
+func HistogramSumTransform() Transform {
+	return newTransform("histogram_sum", ".sum", AddStat("sum", StatNest))
+}
+
+// HistogramBucket returns the name of the histogram bucket of in, counting the values up to and including upperBound.
+// e.g. what=lat.unit=ms -> what=lat.unit=ms.le=0_5 or foo.lat -> foo.lat.bucket.le_0_5
+func HistogramBucket(in string, p Prefixes, upperBound float64) (string, error) {
+	return HistogramBucketTransform(upperBound).Apply(in, p)
+}
+
+// HistogramCount returns the name of the count of values of the histogram in,
+// e.g. what=lat.unit=ms -> what=lat.unit=ms.stat=count or foo.lat -> foo.lat.bucket_count
+func HistogramCount(in string, p Prefixes) (string, error) {
+	return HistogramCountTransform().Apply(in, p)
+}
+
+// HistogramSum returns the name of the sum of values of the histogram in,
+// e.g. what=lat.unit=ms -> what=lat.unit=ms.stat=sum or foo.lat -> foo.lat.sum
+func HistogramSum(in string, p Prefixes) (string, error) {
+	return HistogramSumTransform().Apply(in, p)
+}
+
+// FromPrometheus converts a prometheus series to a metrics 2.0 metric id.
+// typ is the type of the metric family (counter, gauge, histogram, summary or untyped) and unit its unit,
+// as given by the prometheus metadata. both are optional.
+// the name becomes the first node, and labels become tags, cleaned up with cleanTagPart.
+// labels with an empty value are left out, like prometheus does, and unit and mtype labels become orig_unit and orig_mtype.
+// the _bucket, _count and _sum series of histograms are named with HistogramBucket, HistogramCount and HistogramSum.
+// without a type, any series with such a suffix is assumed to be part of a histogram.
+// since prometheus histograms are cumulative, they get mtype=counter, like counters do. anything else is a gauge.
+// the unit is converted with UnitFromUCUM or SuggestUnit. without a unit, the last word of the name is tried,
+// e.g. seconds in http_request_duration_seconds. if that fails, the unit is UnknownUnit.
+func FromPrometheus(name string, labels []Tag, typ, unit string) (string, error) {
+	mtype := MTypeGauge
+	hist := ""
+	switch typ {
+	case "counter":
+		mtype = MTypeCounter
+	case "", "histogram", "summary":
+		for _, suffix := range []string{"_bucket", "_count", "_sum"} {
+			if suffix == "_bucket" && typ == "summary" {
+				continue
+			}
+			if strings.HasSuffix(name, suffix) && len(name) > len(suffix) {
+				name, hist = name[:len(name)-len(suffix)], suffix
+				mtype = MTypeCounter
+				break
+			}
+		}
+	case "gauge", "untyped":
+	default:
+		return "", fmt.Errorf(errFmtUnknownPromType, typ)
+	}
+
+	nodes := []string{cleanTagPart(name)}
+	le := ""
+	for _, l := range labels {
+		if l.Key == "le" && hist == "_bucket" {
+			le = l.Value
+			continue
+		}
+		if l.Value == "" {
+			continue
+		}
+		k := cleanTagPart(l.Key)
+		if k == "unit" || k == "mtype" {
+			k = "orig_" + k
+		}
+		nodes = append(nodes, k+"="+cleanTagPart(l.Value))
+	}
+	nodes = append(nodes, "unit="+promUnit(name, unit), "mtype="+mtype.String())
+	key := strings.Join(nodes, ".")
+
+	switch hist {
+	case "_bucket":
+		if le == "" {
+			return "", errNoLe
+		}
+		b, err := strconv.ParseFloat(le, 64)
+		if err != nil {
+			return "", fmt.Errorf(errFmtInvalidBound, le)
+		}
+		return HistogramBucket(key, Prefixes{}, b)
+	case "_count":
+		return HistogramCount(key, Prefixes{})
+	case "_sum":
+		return HistogramSum(key, Prefixes{})
+	}
+	return key, nil
+}
+
+// promUnit returns the metrics 2.0 unit for a prometheus metric with the given name and unit, see FromPrometheus
+func promUnit(name, unit string) string {
+	if unit == "" {
+		name = strings.TrimSuffix(name, "_total")
+		unit = name[strings.LastIndexByte(name, '_')+1:]
+	}
+	if u, ok := UnitFromUCUM(unit); ok {
+		return u
+	}
+	if u, ok := SuggestUnit(unit); ok && ValidateUnit(u) == nil {
+		return u
+	}
+	return UnknownUnit
+}
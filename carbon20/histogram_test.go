@@ -0,0 +1,103 @@
+package carbon20
+
+import (
+	"math"
+	"testing"
+
+	"github.com/bmizerany/assert"
+)
+
+func TestFormatBound(t *testing.T) {
+	cases := []struct {
+		b   float64
+		out string
+	}{
+		{0.5, "0_5"},
+		{1, "1"},
+		{2.50, "2_5"},
+		{0.001, "0_001"},
+		{-1.5, "-1_5"},
+		{1e21, "1000000000000000000000"},
+		{math.Inf(1), "inf"},
+		{math.Inf(-1), "-inf"},
+	}
+	for _, c := range cases {
+		assert.Equal(t, c.out, FormatBound(c.b))
+		b, err := ParseBound(c.out)
+		assert.Equal(t, nil, err)
+		assert.Equal(t, c.b, b)
+	}
+	for _, s := range []string{"", "0.5", "1e3", "Inf", "NaN", "0_5_1", "abc"} {
+		_, err := ParseBound(s)
+		if err == nil {
+			t.Fatalf("ParseBound(%q): expected error", s)
+		}
+	}
+}
+
+func TestHistogramNames(t *testing.T) {
+	assert.Equal(t, "what=lat.unit=ms.le=0_5", must(HistogramBucket("what=lat.unit=ms", Prefixes{}, 0.5)))
+	assert.Equal(t, "what=lat.le=inf.unit=ms", must(HistogramBucket("what=lat.le=1.unit=ms", Prefixes{}, math.Inf(1))))
+	assert.Equal(t, "what_is_lat.unit_is_ms.le_is_0_25", must(HistogramBucket("what_is_lat.unit_is_ms", Prefixes{}, 0.25)))
+	assert.Equal(t, "foo.lat.bucket.le_0_5", must(HistogramBucket("foo.lat", Prefixes{}, 0.5)))
+	assert.Equal(t, "what=lat.unit=ms.stat=count", must(HistogramCount("what=lat.unit=ms", Prefixes{})))
+	assert.Equal(t, "foo.lat.bucket_count", must(HistogramCount("foo.lat", Prefixes{})))
+	assert.Equal(t, "what=lat.unit=ms.stat=sum", must(HistogramSum("what=lat.unit=ms", Prefixes{})))
+	assert.Equal(t, "foo.lat.sum", must(HistogramSum("foo.lat", Prefixes{})))
+	p, err := NewPrefixes("p.", Tag{"our", "prefix"})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "our=prefix.what=lat.unit=ms.le=1", must(HistogramBucket("what=lat.unit=ms", p, 1)))
+	assert.Equal(t, "p.foo.lat.bucket_count", must(HistogramCount("foo.lat", p)))
+	out, err := DefaultRegistry.Apply("histogram_bucket", "what=lat.unit=ms", Params{UpperBound: 0.5})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "what=lat.unit=ms.le=0_5", out)
+
+	// aggregating buckets across hosts keeps them apart
+	key, err := AggregateKey(must(HistogramBucket("what=lat.host=a.unit=ms", Prefixes{}, 0.5)), Aggregation{Drop: []string{"host"}})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "what=lat.unit=ms.le=0_5.agg_by=host", key)
+}
+
+func TestFromPrometheus(t *testing.T) {
+	cases := []struct {
+		name   string
+		labels []Tag
+		typ    string
+		unit   string
+		out    string
+	}{
+		{"http_latency_bucket", []Tag{{"host", "a.b"}, {"le", "0.5"}}, "", "", "http_latency.host=a_b.unit=Unknown.mtype=counter.le=0_5"},
+		{"http_latency_bucket", []Tag{{"le", "+Inf"}}, "histogram", "ms", "http_latency.unit=ms.mtype=counter.le=inf"},
+		{"http_latency_count", []Tag{{"host", "a"}}, "histogram", "", "http_latency.host=a.unit=Unknown.mtype=counter.stat=count"},
+		{"http_latency_seconds_sum", nil, "histogram", "", "http_latency_seconds.unit=s.mtype=counter.stat=sum"},
+		{"rpc_seconds_count", nil, "summary", "", "rpc_seconds.unit=s.mtype=counter.stat=count"},
+		{"rpc_seconds", []Tag{{"quantile", "0.99"}}, "summary", "", "rpc_seconds.quantile=0_99.unit=s.mtype=gauge"},
+		{"up", []Tag{{"job", "node"}}, "", "", "up.job=node.unit=Unknown.mtype=gauge"},
+		{"up", []Tag{{"job", "node"}, {"dc", ""}}, "gauge", "", "up.job=node.unit=Unknown.mtype=gauge"},
+		{"node_network_receive_bytes_total", []Tag{{"device", "eth0"}}, "counter", "", "node_network_receive_bytes_total.device=eth0.unit=B.mtype=counter"},
+		{"files_open_count", nil, "gauge", "", "files_open_count.unit=Unknown.mtype=gauge"},
+		{"mem", nil, "gauge", "By", "mem.unit=B.mtype=gauge"},
+		{"mem", nil, "untyped", "bytes", "mem.unit=B.mtype=gauge"},
+		{"disk_is_full", []Tag{{"path", "a=b"}, {"this_is_it", "x_is_y"}}, "gauge", "", "disk-is-full.path=a_b.this-is-it=x-is-y.unit=Unknown.mtype=gauge"},
+		{"temp", []Tag{{"unit", "celsius"}, {"mtype", "x"}}, "gauge", "", "temp.orig_unit=celsius.orig_mtype=x.unit=Unknown.mtype=gauge"},
+	}
+	for i, c := range cases {
+		out, err := FromPrometheus(c.name, c.labels, c.typ, c.unit)
+		if err != nil {
+			t.Fatalf("case %d: %s", i, err)
+		}
+		assert.Equal(t, c.out, out)
+		if err := ValidateKeyM20(out, MediumM20); err != nil {
+			t.Fatalf("case %d: %q doesn't validate: %s", i, out, err)
+		}
+		if err := ValidateVocabularyM20(out); err != nil {
+			t.Fatalf("case %d: %q doesn't validate: %s", i, out, err)
+		}
+	}
+	_, err := FromPrometheus("http_latency_bucket", []Tag{{"host", "a"}}, "", "")
+	assert.Equal(t, errNoLe, err)
+	_, err = FromPrometheus("http_latency_bucket", []Tag{{"le", "soon"}}, "histogram", "")
+	assert.Equal(t, `invalid bucket bound "soon"`, err.Error())
+	_, err = FromPrometheus("up", nil, "info", "")
+	assert.Equal(t, `unknown prometheus metric type "info"`, err.Error())
+}
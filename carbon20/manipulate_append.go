@@ -0,0 +1,262 @@
+package carbon20
+
+import (
+	"bytes"
+	"strconv"
+)
+
+// this file contains the AppendX variants of the functions in manipulate.go.
+// they append the output to dst and return the extended buffer, like strconv.AppendInt.
+// their output and errors are identical to the string versions, but they don't allocate
+// as long as dst has enough capacity, and there is no error. on error, dst is returned as is.
+
+// appendSpec describes the changes one of the predefined transforms makes to a metrics 2.0 metric id.
+// the order in which nodes are appended mirrors the order of the steps in the transform.
+type appendSpec struct {
+	op           string // name of the operation, for the transitions table
+	unitSuffix   string // suffix to add to the unit value
+	unit         string // replacement unit value, if any. the original is kept in an orig_unit tag
+	mtype        string // replacement mtype value, if any
+	addMType     bool   // whether to append the mtype tag if it's not present
+	pckt         bool   // whether to append pckt_type=sent and direction=in tags
+	legacySuffix string // suffix to add to legacy metrics
+}
+
+var (
+	specDeriveCount   = appendSpec{op: "derive_count", unitSuffix: "ps", mtype: "rate", legacySuffix: ".rate"}
+	specDeriveCountM1 = appendSpec{op: "derive_count", unitSuffix: "ps", mtype: "rate"}
+	specCountPckt     = appendSpec{op: "count_pckt", unit: "Pckt", mtype: "count", pckt: true, legacySuffix: ".count"}
+	specCountMetric   = appendSpec{op: "count_metric", unit: "Metric", mtype: "count", legacySuffix: ".count"}
+	specCount         = appendSpec{op: "count", mtype: "count", addMType: true, legacySuffix: ".count"}
+	specCountM1       = appendSpec{op: "count", mtype: "count", addMType: true}
+	specCounter       = appendSpec{op: "counter", mtype: "counter", addMType: true, legacySuffix: ".counter"}
+	specRatePckt      = appendSpec{op: "rate_pckt", unit: "Pcktps", mtype: "rate", pckt: true, legacySuffix: ".count_ps"}
+	specGauge         = appendSpec{op: "gauge"}
+)
+
+var (
+	m20OrigUnitPre   = []byte("orig_unit=")
+	m20NEOrigUnitPre = []byte("orig_unit_is_")
+	m20Pckt          = []byte(".pckt_type=sent.direction=in")
+	m20NEPckt        = []byte(".pckt_type_is_sent.direction_is_in")
+	m20StatPre       = []byte(".stat=")
+	m20NEStatPre     = []byte(".stat_is_")
+)
+
+// getVersionAllB is like GetVersion, but for byte array input.
+// unlike GetVersionB it looks at the whole metric, not just the first node.
+func getVersionAllB(in []byte) metricVersion {
+	if bytes.IndexByte(in, '=') >= 0 {
+		return M20
+	}
+	if bytes.Contains(in, m20Is) {
+		return M20NoEquals
+	}
+	return Legacy
+}
+
+// nextNode returns the node at the start of in, and what comes after the dot that terminates it.
+// more is false if this is the last node.
+func nextNode(in []byte) (node, rest []byte, more bool) {
+	i := bytes.IndexByte(in, '.')
+	if i < 0 {
+		return in, nil, false
+	}
+	return in[:i], in[i+1:], true
+}
+
+// appendSpecTo appends the result of applying spec to in to dst
+func appendSpecTo(dst, in []byte, p Prefixes, spec *appendSpec) ([]byte, error) {
+	err := transitions[spec.op].checkB(spec.op, in)
+	if err != nil {
+		return dst, err
+	}
+	ver := getVersionAllB(in)
+	if ver == Legacy {
+		dst = append(dst, p.legacy...)
+		dst = append(dst, in...)
+		return append(dst, spec.legacySuffix...), nil
+	}
+	unitPre, mtypePre, origPre, pckt := m20UnitPre, m20MTPre, m20OrigUnitPre, m20Pckt
+	if ver == M20NoEquals {
+		unitPre, mtypePre, origPre, pckt = m20NEUnitPre, m20NEMTPre, m20NEOrigUnitPre, m20NEPckt
+	}
+	dst = append(dst, p.For(ver)...)
+
+	seenMType := false
+	for rest, more := in, true; more; {
+		var node []byte
+		node, rest, more = nextNode(rest)
+		switch {
+		case bytes.HasPrefix(node, unitPre) && spec.unit != "":
+			dst = append(dst, unitPre...)
+			dst = append(dst, spec.unit...)
+		case bytes.HasPrefix(node, unitPre):
+			dst = append(dst, node...)
+			dst = append(dst, spec.unitSuffix...)
+		case bytes.HasPrefix(node, mtypePre) && spec.mtype != "":
+			seenMType = true
+			dst = append(dst, mtypePre...)
+			dst = append(dst, spec.mtype...)
+		default:
+			dst = append(dst, node...)
+		}
+		if more {
+			dst = append(dst, '.')
+		}
+	}
+
+	if spec.unit != "" {
+		for rest, more := in, true; more; {
+			var node []byte
+			node, rest, more = nextNode(rest)
+			if bytes.HasPrefix(node, unitPre) {
+				dst = append(dst, '.')
+				dst = append(dst, origPre...)
+				dst = append(dst, node[len(unitPre):]...)
+			}
+		}
+	}
+	if spec.addMType && !seenMType {
+		dst = append(dst, '.')
+		dst = append(dst, mtypePre...)
+		dst = append(dst, spec.mtype...)
+	}
+	if spec.pckt {
+		dst = append(dst, pckt...)
+	}
+	return dst, nil
+}
+
+// AppendDeriveCount is like DeriveCount, but appends to dst
+func AppendDeriveCount(dst, in []byte, p Prefixes, m1Legacy bool) ([]byte, error) {
+	if m1Legacy {
+		return appendSpecTo(dst, in, p, &specDeriveCountM1)
+	}
+	return appendSpecTo(dst, in, p, &specDeriveCount)
+}
+
+// AppendGauge is like Gauge, but appends to dst
+func AppendGauge(dst, in []byte, p Prefixes) ([]byte, error) {
+	return appendSpecTo(dst, in, p, &specGauge)
+}
+
+// appendStatValue appends the value of a stat tag, or a legacy stat node, like statValue
+func appendStatValue(dst []byte, stat, percentile string, timespec Timespec) []byte {
+	dst = append(dst, stat...)
+	if percentile != "" {
+		dst = append(dst, '_')
+		dst = append(dst, percentile...)
+	}
+	if !timespec.IsZero() {
+		dst = append(dst, "__"...)
+		dst = strconv.AppendInt(dst, int64(timespec.N), 10)
+		dst = append(dst, timespec.Unit...)
+	}
+	return dst
+}
+
+// appendSimpleStat is like simpleStat, but appends to dst
+func appendSimpleStat(dst, in []byte, p Prefixes, stat1, stat2, percentile string, timespec Timespec) ([]byte, error) {
+	err := validateStatParams(percentile, timespec)
+	if err != nil {
+		return dst, err
+	}
+	err = transition{}.checkB(stat2, in)
+	if err != nil {
+		return dst, err
+	}
+	ver := getVersionAllB(in)
+	dst = append(dst, p.For(ver)...)
+	if ver == Legacy {
+		dst = append(dst, in...)
+		dst = append(dst, '.')
+		return appendStatValue(dst, stat1, percentile, timespec), nil
+	}
+	statPre := m20StatPre
+	if ver == M20NoEquals {
+		statPre = m20NEStatPre
+	}
+
+	// statPre includes the leading dot
+	seenStat := false
+	for rest, more := in, true; more; {
+		var node []byte
+		node, rest, more = nextNode(rest)
+		if bytes.HasPrefix(node, statPre[1:]) {
+			seenStat = true
+			dst = append(dst, statPre[1:]...)
+			dst = appendStatValue(dst, stat2, percentile, timespec)
+			dst = append(dst, statNest...)
+			dst = append(dst, node[len(statPre)-1:]...)
+		} else {
+			dst = append(dst, node...)
+		}
+		if more {
+			dst = append(dst, '.')
+		}
+	}
+	if seenStat {
+		return dst, nil
+	}
+	dst = append(dst, statPre...)
+	return appendStatValue(dst, stat2, percentile, timespec), nil
+}
+
+// AppendMax is like Max, but appends to dst
+func AppendMax(dst, in []byte, p Prefixes, percentile string, timespec Timespec) ([]byte, error) {
+	return appendSimpleStat(dst, in, p, "upper", "max", percentile, timespec)
+}
+
+// AppendMin is like Min, but appends to dst
+func AppendMin(dst, in []byte, p Prefixes, percentile string, timespec Timespec) ([]byte, error) {
+	return appendSimpleStat(dst, in, p, "lower", "min", percentile, timespec)
+}
+
+// AppendMean is like Mean, but appends to dst
+func AppendMean(dst, in []byte, p Prefixes, percentile string, timespec Timespec) ([]byte, error) {
+	return appendSimpleStat(dst, in, p, "mean", "mean", percentile, timespec)
+}
+
+// AppendSum is like Sum, but appends to dst
+func AppendSum(dst, in []byte, p Prefixes, percentile string, timespec Timespec) ([]byte, error) {
+	return appendSimpleStat(dst, in, p, "sum", "sum", percentile, timespec)
+}
+
+// AppendMedian is like Median, but appends to dst
+func AppendMedian(dst, in []byte, p Prefixes, percentile string, timespec Timespec) ([]byte, error) {
+	return appendSimpleStat(dst, in, p, "median", "median", percentile, timespec)
+}
+
+// AppendStd is like Std, but appends to dst
+func AppendStd(dst, in []byte, p Prefixes, percentile string, timespec Timespec) ([]byte, error) {
+	return appendSimpleStat(dst, in, p, "std", "std", percentile, timespec)
+}
+
+// AppendCountPckt is like CountPckt, but appends to dst
+func AppendCountPckt(dst, in []byte, p Prefixes) ([]byte, error) {
+	return appendSpecTo(dst, in, p, &specCountPckt)
+}
+
+// AppendCountMetric is like CountMetric, but appends to dst
+func AppendCountMetric(dst, in []byte, p Prefixes) ([]byte, error) {
+	return appendSpecTo(dst, in, p, &specCountMetric)
+}
+
+// AppendCount is like Count, but appends to dst
+func AppendCount(dst, in []byte, p Prefixes, m1Legacy bool) ([]byte, error) {
+	if m1Legacy {
+		return appendSpecTo(dst, in, p, &specCountM1)
+	}
+	return appendSpecTo(dst, in, p, &specCount)
+}
+
+// AppendCounter is like Counter, but appends to dst
+func AppendCounter(dst, in []byte, p Prefixes) ([]byte, error) {
+	return appendSpecTo(dst, in, p, &specCounter)
+}
+
+// AppendRatePckt is like RatePckt, but appends to dst
+func AppendRatePckt(dst, in []byte, p Prefixes) ([]byte, error) {
+	return appendSpecTo(dst, in, p, &specRatePckt)
+}
@@ -0,0 +1,202 @@
+package carbon20
+
+import (
+	"fmt"
+	"testing"
+)
+
+var appendInputs = []string{
+	"foo.bar",
+	"foo.bar.count",
+	"foo.bar.unit=yes.baz",
+	"foo.bar.unit=yes",
+	"unit=yes.foo.bar",
+	"mtype=count.foo.unit=ok.bar",
+	"mtype=counter.foo.unit=ok.bar",
+	"what=rx.unit=B.mtype=gauge.unit=b",
+	"what=rx.mtype=gauge.mtype=rate",
+	"what=rx.unit=B.mtype=bogus",
+	"what_is_rx.unit_is_B.mtype_is_rate",
+	"foo.bar.unit_is_yes.baz",
+	"unit_is_yes.foo.bar",
+	"mtype_is_count.foo.unit_is_ok.bar",
+	"what_is_rx.unit_is_B",
+	"what=lat.stat=mean.unit=ms",
+	"what_is_lat.stat_is_mean_90__1m",
+	"foo=bar",
+	"",
+}
+
+type appendCase struct {
+	name   string
+	str    func(in string) (string, error)
+	append func(dst, in []byte) ([]byte, error)
+}
+
+var appendPrefixes, _ = NewPrefixes("p1.", Tag{"p", "2"})
+
+var appendCases = []appendCase{
+	{"DeriveCount",
+		func(in string) (string, error) { return DeriveCount(in, appendPrefixes, false) },
+		func(dst, in []byte) ([]byte, error) {
+			return AppendDeriveCount(dst, in, appendPrefixes, false)
+		}},
+	{"DeriveCountM1",
+		func(in string) (string, error) { return DeriveCount(in, appendPrefixes, true) },
+		func(dst, in []byte) ([]byte, error) {
+			return AppendDeriveCount(dst, in, appendPrefixes, true)
+		}},
+	{"Gauge",
+		func(in string) (string, error) { return Gauge(in, appendPrefixes) },
+		func(dst, in []byte) ([]byte, error) { return AppendGauge(dst, in, appendPrefixes) }},
+	{"Max",
+		func(in string) (string, error) { return Max(in, appendPrefixes, "90", Timespec{1, "m"}) },
+		func(dst, in []byte) ([]byte, error) {
+			return AppendMax(dst, in, appendPrefixes, "90", Timespec{1, "m"})
+		}},
+	{"Min",
+		func(in string) (string, error) { return Min(in, appendPrefixes, "", Timespec{1, "m"}) },
+		func(dst, in []byte) ([]byte, error) { return AppendMin(dst, in, appendPrefixes, "", Timespec{1, "m"}) }},
+	{"Mean",
+		func(in string) (string, error) { return Mean(in, appendPrefixes, "90", Timespec{}) },
+		func(dst, in []byte) ([]byte, error) { return AppendMean(dst, in, appendPrefixes, "90", Timespec{}) }},
+	{"Sum",
+		func(in string) (string, error) { return Sum(in, appendPrefixes, "", Timespec{}) },
+		func(dst, in []byte) ([]byte, error) { return AppendSum(dst, in, appendPrefixes, "", Timespec{}) }},
+	{"Median",
+		func(in string) (string, error) { return Median(in, appendPrefixes, "", Timespec{}) },
+		func(dst, in []byte) ([]byte, error) { return AppendMedian(dst, in, appendPrefixes, "", Timespec{}) }},
+	{"Std",
+		func(in string) (string, error) { return Std(in, appendPrefixes, "", Timespec{}) },
+		func(dst, in []byte) ([]byte, error) { return AppendStd(dst, in, appendPrefixes, "", Timespec{}) }},
+	{"CountPckt",
+		func(in string) (string, error) { return CountPckt(in, appendPrefixes) },
+		func(dst, in []byte) ([]byte, error) { return AppendCountPckt(dst, in, appendPrefixes) }},
+	{"CountMetric",
+		func(in string) (string, error) { return CountMetric(in, appendPrefixes) },
+		func(dst, in []byte) ([]byte, error) { return AppendCountMetric(dst, in, appendPrefixes) }},
+	{"Count",
+		func(in string) (string, error) { return Count(in, appendPrefixes, false) },
+		func(dst, in []byte) ([]byte, error) { return AppendCount(dst, in, appendPrefixes, false) }},
+	{"CountM1",
+		func(in string) (string, error) { return Count(in, appendPrefixes, true) },
+		func(dst, in []byte) ([]byte, error) { return AppendCount(dst, in, appendPrefixes, true) }},
+	{"Counter",
+		func(in string) (string, error) { return Counter(in, appendPrefixes) },
+		func(dst, in []byte) ([]byte, error) { return AppendCounter(dst, in, appendPrefixes) }},
+	{"RatePckt",
+		func(in string) (string, error) { return RatePckt(in, appendPrefixes) },
+		func(dst, in []byte) ([]byte, error) { return AppendRatePckt(dst, in, appendPrefixes) }},
+}
+
+func TestAppendMatchesString(t *testing.T) {
+	for _, c := range appendCases {
+		for _, in := range appendInputs {
+			exp, expErr := c.str(in)
+			got, err := c.append([]byte("junk"), []byte(in))
+			if string(got) != "junk"+exp || fmt.Sprint(err) != fmt.Sprint(expErr) {
+				t.Fatalf("%s(%q): expected %q, %v, got %q, %v", c.name, in, "junk"+exp, expErr, got, err)
+			}
+		}
+	}
+}
+
+func TestAppendAllocs(t *testing.T) {
+	dst := make([]byte, 0, 512)
+	for _, c := range appendCases {
+		for _, in := range appendInputs {
+			if _, err := c.str(in); err != nil {
+				continue
+			}
+			inB := []byte(in)
+			allocs := testing.AllocsPerRun(100, func() {
+				dst, _ = c.append(dst[:0], inB)
+			})
+			if allocs != 0 {
+				t.Fatalf("%s(%q): expected 0 allocations, got %v", c.name, in, allocs)
+			}
+		}
+	}
+}
+
+var outB []byte
+
+func BenchmarkAppendDeriveCountM20Proper(b *testing.B) {
+	in := []byte("foo=bar.unit=yes.mtype=count")
+	dst := make([]byte, 0, 128)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		dst, _ = AppendDeriveCount(dst[:0], in, benchPrefixes, false)
+	}
+	outB = dst
+}
+
+func BenchmarkAppendDeriveCountM20NoEqualsProper(b *testing.B) {
+	in := []byte("foo_is_bar.unit_is_yes.mtype_is_count")
+	dst := make([]byte, 0, 128)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		dst, _ = AppendDeriveCount(dst[:0], in, benchPrefixes, false)
+	}
+	outB = dst
+}
+
+func BenchmarkAppendCountPcktM20(b *testing.B) {
+	in := []byte("foo=bar.unit=yes.mtype=count")
+	dst := make([]byte, 0, 128)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		dst, _ = AppendCountPckt(dst[:0], in, benchPrefixes)
+	}
+	outB = dst
+}
+
+func BenchmarkAppendRatePcktM20(b *testing.B) {
+	in := []byte("foo=bar.unit=yes.mtype=count")
+	dst := make([]byte, 0, 128)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		dst, _ = AppendRatePckt(dst[:0], in, benchPrefixes)
+	}
+	outB = dst
+}
+
+func BenchmarkAppendCountM20(b *testing.B) {
+	in := []byte("foo=bar.unit=yes")
+	dst := make([]byte, 0, 128)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		dst, _ = AppendCount(dst[:0], in, benchPrefixes, false)
+	}
+	outB = dst
+}
+
+func BenchmarkAppendCounterM20(b *testing.B) {
+	in := []byte("foo=bar.unit=yes")
+	dst := make([]byte, 0, 128)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		dst, _ = AppendCounter(dst[:0], in, benchPrefixes)
+	}
+	outB = dst
+}
+
+func BenchmarkAppendMaxM20(b *testing.B) {
+	in := []byte("foo=bar.unit=yes.mtype=gauge")
+	dst := make([]byte, 0, 128)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		dst, _ = AppendMax(dst[:0], in, benchPrefixes, "90", Timespec{})
+	}
+	outB = dst
+}
+
+func BenchmarkAppendMaxLegacy(b *testing.B) {
+	in := []byte("foo.bar.baz")
+	dst := make([]byte, 0, 128)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		dst, _ = AppendMax(dst[:0], in, benchPrefixes, "90", Timespec{})
+	}
+	outB = dst
+}
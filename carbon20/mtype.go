@@ -0,0 +1,138 @@
+package carbon20
+
+import (
+	"errors"
+	"fmt"
+)
+
+var errUnknownMType = errors.New("unknown mtype")
+
+var errFmtIllegalTransition = "%s can't be applied to mtype=%s"
+
+// MType is the metrics 2.0 metric type, as set in the mtype tag.
+// see http://metrics20.org/spec/#tag-values-mtype
+//
+//go:generate stringer -type=MType -linecomment
+type MType int
+
+const (
+	MTypeGauge     MType = iota // gauge
+	MTypeCount                  // count
+	MTypeRate                   // rate
+	MTypeCounter                // counter
+	MTypeTimestamp              // timestamp
+)
+
+var mtypeValues = map[string]MType{
+	"gauge":     MTypeGauge,
+	"count":     MTypeCount,
+	"rate":      MTypeRate,
+	"counter":   MTypeCounter,
+	"timestamp": MTypeTimestamp,
+}
+
+// ParseMType parses the value of an mtype tag
+func ParseMType(s string) (MType, error) {
+	if m, ok := mtypeValues[s]; ok {
+		return m, nil
+	}
+	return 0, fmt.Errorf("%s: %q", errUnknownMType, s)
+}
+
+// parseMTypeB is like ParseMType, but for byte array input. it only allocates for unknown mtypes.
+func parseMTypeB(s []byte) (MType, error) {
+	if m, ok := mtypeValues[string(s)]; ok {
+		return m, nil
+	}
+	return 0, fmt.Errorf("%s: %q", errUnknownMType, s)
+}
+
+// transition describes which metrics an operation can be applied to
+type transition struct {
+	from     []MType // mtypes the operation can be applied to. nil means any.
+	required bool    // whether the operation needs an mtype tag to be present
+}
+
+// transitions lists the legal transitions for operations that change the mtype.
+// operations not listed can be applied to any metric.
+var transitions = map[string]transition{
+	"derive_count": {[]MType{MTypeCounter, MTypeCount}, true},
+	"count":        {[]MType{MTypeGauge, MTypeCount, MTypeCounter}, false},
+	"counter":      {[]MType{MTypeGauge, MTypeCount, MTypeCounter}, false},
+	"count_pckt":   {nil, false},
+	"count_metric": {nil, false},
+	"rate_pckt":    {nil, false},
+
+	"integrate_rate":            {[]MType{MTypeRate}, true},
+	"integrate_rate_cumulative": {[]MType{MTypeRate}, true},
+}
+
+// CheckTransition returns an error if the named operation can't be applied to the metric,
+// based on its mtype tag. legacy metrics have no mtype, so anything goes for them.
+func CheckTransition(op, in string) error {
+	tr, ok := transitions[op]
+	if !ok {
+		return nil
+	}
+	return tr.check(op, in)
+}
+
+// check returns an error if the transition doesn't allow the named operation to be applied to the metric.
+// the mtype is only parsed, and rejected if it is unknown, if the transition restricts it.
+func (tr transition) check(op, in string) error {
+	if GetVersion(in) == Legacy {
+		return nil
+	}
+	val, ok := GetTag(in, "mtype")
+	if !ok {
+		return tr.checkMissing()
+	}
+	if tr.from == nil {
+		return nil
+	}
+	mtype, err := ParseMType(val)
+	if err != nil {
+		return err
+	}
+	return tr.checkFrom(op, mtype)
+}
+
+// checkB is like check, but for byte array input. it doesn't allocate, unless there's an error.
+func (tr transition) checkB(op string, in []byte) error {
+	ver := getVersionAllB(in)
+	if ver == Legacy {
+		return nil
+	}
+	pre := m20MTPre
+	if ver == M20NoEquals {
+		pre = m20NEMTPre
+	}
+	val := tagValueB(in, pre)
+	if val == nil {
+		return tr.checkMissing()
+	}
+	if tr.from == nil {
+		return nil
+	}
+	mtype, err := parseMTypeB(val)
+	if err != nil {
+		return err
+	}
+	return tr.checkFrom(op, mtype)
+}
+
+func (tr transition) checkMissing() error {
+	if tr.required {
+		return errNoMType
+	}
+	return nil
+}
+
+func (tr transition) checkFrom(op string, mtype MType) error {
+	for _, m := range tr.from {
+		if m == mtype {
+			return nil
+		}
+	}
+	return fmt.Errorf(errFmtIllegalTransition, op, mtype)
+}
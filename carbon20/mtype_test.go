@@ -0,0 +1,95 @@
+package carbon20
+
+import (
+	"testing"
+
+	"github.com/bmizerany/assert"
+)
+
+func TestParseMType(t *testing.T) {
+	for _, m := range []MType{MTypeGauge, MTypeCount, MTypeRate, MTypeCounter, MTypeTimestamp} {
+		parsed, err := ParseMType(m.String())
+		assert.Equal(t, nil, err)
+		assert.Equal(t, m, parsed)
+	}
+	for _, in := range []string{"", "Gauge", "counters", "histogram"} {
+		_, err := ParseMType(in)
+		if err == nil {
+			t.Fatalf("ParseMType(%q): expected error", in)
+		}
+	}
+}
+
+func TestCheckTransition(t *testing.T) {
+	cases := []struct {
+		op    string
+		in    string
+		valid bool
+	}{
+		{"derive_count", "foo.bar", true},
+		{"derive_count", "what=rx.unit=B.mtype=counter", true},
+		{"derive_count", "what=rx.unit=B.mtype=count", true},
+		{"derive_count", "what_is_rx.unit_is_B.mtype_is_count", true},
+		{"derive_count", "what=rx.unit=B.mtype=gauge", false},
+		{"derive_count", "what=rx.unit=B.mtype=rate", false},
+		{"derive_count", "what=rx.unit=B", false},
+		{"derive_count", "what=rx.unit=B.mtype=bogus", false},
+		{"count", "what=rx.unit=B", true},
+		{"count", "what=rx.unit=B.mtype=gauge", true},
+		{"count", "what=rx.unit=B.mtype=rate", false},
+		{"counter", "what=rx.unit=B.mtype=timestamp", false},
+		{"count_pckt", "what=rx.unit=B.mtype=rate", true},
+		{"count_pckt", "what=rx.unit=B.mtype=bogus", true},
+		{"count", "what=rx.unit=B.mtype=bogus", false},
+		{"max", "what=rx.unit=B.mtype=bogus", true},
+	}
+	for i, c := range cases {
+		err := CheckTransition(c.op, c.in)
+		if (err == nil) != c.valid {
+			t.Fatalf("case %d: CheckTransition(%q, %q): expected valid=%t, got err=%v", i, c.op, c.in, c.valid, err)
+		}
+	}
+	_, err := DeriveCount("what=rx.unit=B.mtype=gauge", Prefixes{}, false)
+	assert.Equal(t, "derive_count can't be applied to mtype=gauge", err.Error())
+	_, err = DeriveCount("what=rx.unit=B", Prefixes{}, false)
+	assert.Equal(t, errNoMType, err)
+	out, err := DeriveCount("what=rx.unit=B.mtype=counter", Prefixes{}, false)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "what=rx.unit=Bps.mtype=rate", out)
+
+	// operations that don't restrict the mtype accept any, as the vocabulary is opt-in
+	hist := "what=lat.unit=ms.mtype=histogram"
+	for _, fn := range []func(in string) (string, error){
+		func(in string) (string, error) { return Gauge(in, Prefixes{}) },
+		func(in string) (string, error) { return CountPckt(in, Prefixes{}) },
+		func(in string) (string, error) { return Max(in, Prefixes{}, "", Timespec{}) },
+		func(in string) (string, error) { return Mean(in, Prefixes{}, "90", Timespec{1, "m"}) },
+	} {
+		_, err := fn(hist)
+		assert.Equal(t, nil, err)
+	}
+	out, err = Max(hist, Prefixes{}, "", Timespec{})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "what=lat.unit=ms.mtype=histogram.stat=max", out)
+	buf, err := AppendGauge(nil, []byte(hist), Prefixes{})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, hist, string(buf))
+	_, err = Count(hist, Prefixes{}, false)
+	assert.Equal(t, `unknown mtype: "histogram"`, err.Error())
+}
+
+func TestValidateVocabularyM20MType(t *testing.T) {
+	cases := []struct {
+		in    string
+		valid bool
+	}{
+		{"what=rx.unit=B.mtype=gauge", true},
+		{"what=rx.unit=B.mtype=timestamp", true},
+		{"what=rx.unit=B.mtype=bogus", false},
+	}
+	for _, c := range cases {
+		assert.Equal(t, c.valid, ValidateVocabularyM20(c.in) == nil)
+		assert.Equal(t, c.valid, ValidateVocabularyM20B([]byte(c.in)) == nil)
+		assert.Equal(t, true, ValidateKeyM20(c.in, StrictM20) == nil)
+	}
+}
@@ -0,0 +1,457 @@
+package carbon20
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// this file maps metrics to the OpenTelemetry metrics data model, serialized as OTLP/JSON.
+// see https://opentelemetry.io/docs/specs/otel/protocol/file-exporter/ for the json encoding.
+//
+// mapping rules:
+// * mtype=gauge, rate, timestamp (or no mtype) -> gauge
+// * mtype=count   -> sum with delta temporality, not monotonic
+// * mtype=counter -> sum with cumulative temporality, monotonic
+// * the unit tag becomes the metric unit, converted to UCUM (see UnitToUCUM)
+// * all other tags become string attributes
+// * nodes that are not tags are joined together as the metric name. without such nodes, the what tag is the name
+// * dots and = in attribute keys and values become underscores when converting back (see cleanTagPart).
+//   attributes named unit, mtype or ucum_unit get an orig_ prefix, and attributes with an empty value are left out
+// * legacy metrics become a gauge named after the whole metric, without attributes
+// when converting back, metrics 2.0 ids are always written in the = style.
+
+var errNoDataPoints = errors.New("otlp metric has no data points")
+var errNoOTLPData = errors.New("otlp metric has no gauge, sum or histogram")
+var errBucketCounts = errors.New("histogram must have exactly one more bucket count than bounds")
+var errNoOTLPName = errors.New("metric has no untagged nodes or what tag to use as otlp name")
+var errOTLPNegativeTs = errors.New("otlp timestamps can't be before 1970")
+
+// OTLPTemporality is the AggregationTemporality of an OTLP sum or histogram
+type OTLPTemporality int
+
+const (
+	OTLPTemporalityUnspecified OTLPTemporality = iota
+	OTLPTemporalityDelta
+	OTLPTemporalityCumulative
+)
+
+// otlpUint64 is a uint64 which OTLP/JSON encodes as a decimal string.
+// numbers are also accepted when decoding.
+type otlpUint64 uint64
+
+func (u otlpUint64) MarshalJSON() ([]byte, error) {
+	return []byte(`"` + strconv.FormatUint(uint64(u), 10) + `"`), nil
+}
+
+func (u *otlpUint64) UnmarshalJSON(data []byte) error {
+	s := strings.Trim(string(data), `"`)
+	v, err := strconv.ParseUint(s, 10, 64)
+	if err != nil {
+		return err
+	}
+	*u = otlpUint64(v)
+	return nil
+}
+
+// OTLPAnyValue is an attribute value. we only write strings, but accept the other scalars
+type OTLPAnyValue struct {
+	StringValue *string  `json:"stringValue,omitempty"`
+	IntValue    *string  `json:"intValue,omitempty"`
+	DoubleValue *float64 `json:"doubleValue,omitempty"`
+	BoolValue   *bool    `json:"boolValue,omitempty"`
+}
+
+// String returns the value formatted as a string
+func (v OTLPAnyValue) String() string {
+	switch {
+	case v.StringValue != nil:
+		return *v.StringValue
+	case v.IntValue != nil:
+		return *v.IntValue
+	case v.DoubleValue != nil:
+		return strconv.FormatFloat(*v.DoubleValue, 'f', -1, 64)
+	case v.BoolValue != nil:
+		return strconv.FormatBool(*v.BoolValue)
+	}
+	return ""
+}
+
+type OTLPKeyValue struct {
+	Key   string       `json:"key"`
+	Value OTLPAnyValue `json:"value"`
+}
+
+type OTLPNumberDataPoint struct {
+	Attributes   []OTLPKeyValue `json:"attributes,omitempty"`
+	TimeUnixNano otlpUint64     `json:"timeUnixNano"`
+	AsDouble     float64        `json:"asDouble"`
+}
+
+type OTLPHistogramDataPoint struct {
+	Attributes     []OTLPKeyValue `json:"attributes,omitempty"`
+	TimeUnixNano   otlpUint64     `json:"timeUnixNano"`
+	Count          otlpUint64     `json:"count"`
+	Sum            float64        `json:"sum"`
+	BucketCounts   []otlpUint64   `json:"bucketCounts"`
+	ExplicitBounds []float64      `json:"explicitBounds"`
+}
+
+type OTLPGauge struct {
+	DataPoints []OTLPNumberDataPoint `json:"dataPoints"`
+}
+
+type OTLPSum struct {
+	DataPoints             []OTLPNumberDataPoint `json:"dataPoints"`
+	AggregationTemporality OTLPTemporality       `json:"aggregationTemporality"`
+	IsMonotonic            bool                  `json:"isMonotonic"`
+}
+
+type OTLPHistogram struct {
+	DataPoints             []OTLPHistogramDataPoint `json:"dataPoints"`
+	AggregationTemporality OTLPTemporality          `json:"aggregationTemporality"`
+}
+
+// OTLPMetric is an OTLP metric. exactly one of Gauge, Sum and Histogram is set.
+type OTLPMetric struct {
+	Name      string         `json:"name"`
+	Unit      string         `json:"unit,omitempty"`
+	Gauge     *OTLPGauge     `json:"gauge,omitempty"`
+	Sum       *OTLPSum       `json:"sum,omitempty"`
+	Histogram *OTLPHistogram `json:"histogram,omitempty"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpScopeMetrics struct {
+	Scope   otlpScope    `json:"scope"`
+	Metrics []OTLPMetric `json:"metrics"`
+}
+
+type otlpResourceMetrics struct {
+	ScopeMetrics []otlpScopeMetrics `json:"scopeMetrics"`
+}
+
+type otlpMetricsData struct {
+	ResourceMetrics []otlpResourceMetrics `json:"resourceMetrics"`
+}
+
+// otlpScopeName is the instrumentation scope we report in OTLP/JSON documents
+const otlpScopeName = "github.com/metrics20/go-metrics20/carbon20"
+
+// Point is a single value of a metric at a given time
+type Point struct {
+	Key   string
+	Value float64
+	Ts    int64 // in the precision given to the function that returned the point
+}
+
+// otlpSplit splits a metric id into the OTLP name, UCUM unit, mtype and attributes.
+// the what tag is also kept as attribute, so otlpJoin can tell it was used as the name.
+func otlpSplit(key string) (name, unit, mtype string, attrs []OTLPKeyValue, err error) {
+	ver := GetVersion(key)
+	if ver == Legacy {
+		return key, "", "", nil, nil
+	}
+	sep := tagSep(ver)
+	var names []string
+	var ucum, what string
+	for _, node := range strings.Split(key, ".") {
+		k, v, ok := splitTag(node, sep)
+		switch {
+		case !ok:
+			names = append(names, node)
+		case k == "unit":
+			unit = v
+		case k == UCUMTagKey:
+			ucum = v
+		case k == "mtype":
+			mtype = v
+		default:
+			if k == "what" {
+				what = v
+			}
+			val := v
+			attrs = append(attrs, OTLPKeyValue{k, OTLPAnyValue{StringValue: &val}})
+		}
+	}
+	name = strings.Join(names, ".")
+	if name == "" {
+		if what == "" {
+			return "", "", "", nil, errNoOTLPName
+		}
+		name = what
+	}
+	if unit, err = ucumFromTags(unit, ucum); err != nil {
+		return "", "", "", nil, err
+	}
+	return name, unit, mtype, attrs, nil
+}
+
+// otlpJoin is the inverse of otlpSplit.
+// the name is left out if a what attribute has the same value, as otlpSplit took it from there.
+// attribute keys and values are cleaned up with cleanTagPart, as they may have dots and such,
+// and keys that otlpJoin sets itself get an orig_ prefix, like FromPrometheus does.
+func otlpJoin(name, ucum, mtype string, attrs []OTLPKeyValue) string {
+	if ucum == "" && mtype == "" && len(attrs) == 0 {
+		return name
+	}
+	var nodes []string
+	tags := make([]string, 0, len(attrs))
+	for _, a := range attrs {
+		k, v := cleanTagPart(a.Key), cleanTagPart(a.Value.String())
+		if k == "" || v == "" {
+			continue
+		}
+		switch k {
+		case "unit", "mtype", UCUMTagKey:
+			k = "orig_" + k
+		}
+		if k == "what" && v == name {
+			name = ""
+		}
+		tags = append(tags, k+"="+v)
+	}
+	if name != "" {
+		nodes = append(nodes, name)
+	}
+	nodes = append(nodes, tags...)
+	unit, ucumTag := unitFromUCUMTag(ucum)
+	if ucumTag != "" {
+		nodes = append(nodes, UCUMTagKey+"="+ucumTag)
+	}
+	if unit != "" {
+		nodes = append(nodes, "unit="+unit)
+	}
+	if mtype != "" {
+		nodes = append(nodes, "mtype="+mtype)
+	}
+	return strings.Join(nodes, ".")
+}
+
+// otlpNanos converts ts, which has precision p, to an OTLP timestamp
+func otlpNanos(ts int64, p Precision) (otlpUint64, error) {
+	ns, err := tsNanos(ts, p)
+	if err != nil {
+		return 0, err
+	}
+	if ns < 0 {
+		return 0, errOTLPNegativeTs
+	}
+	return otlpUint64(ns), nil
+}
+
+// otlpTs converts an OTLP timestamp to precision p
+func otlpTs(ns otlpUint64, p Precision) (int64, error) {
+	unit, err := p.nanos()
+	if err != nil {
+		return 0, err
+	}
+	if ns > math.MaxInt64 {
+		return 0, errTsOverflow
+	}
+	return int64(ns) / unit, nil
+}
+
+// ToOTLP converts a single point to an OTLP gauge or sum, based on its mtype. ts has precision p.
+func ToOTLP(key string, value float64, ts int64, p Precision) (OTLPMetric, error) {
+	name, unit, mtype, attrs, err := otlpSplit(key)
+	if err != nil {
+		return OTLPMetric{}, err
+	}
+	ns, err := otlpNanos(ts, p)
+	if err != nil {
+		return OTLPMetric{}, err
+	}
+	m := OTLPMetric{Name: name, Unit: unit}
+	dp := []OTLPNumberDataPoint{{Attributes: attrs, TimeUnixNano: ns, AsDouble: value}}
+	if mtype == "" {
+		m.Gauge = &OTLPGauge{DataPoints: dp}
+		return m, nil
+	}
+	mt, err := ParseMType(mtype)
+	if err != nil {
+		return m, err
+	}
+	switch mt {
+	case MTypeCount:
+		m.Sum = &OTLPSum{DataPoints: dp, AggregationTemporality: OTLPTemporalityDelta}
+	case MTypeCounter:
+		m.Sum = &OTLPSum{DataPoints: dp, AggregationTemporality: OTLPTemporalityCumulative, IsMonotonic: true}
+	default:
+		m.Gauge = &OTLPGauge{DataPoints: dp}
+	}
+	return m, nil
+}
+
+// ToOTLPHistogram converts a histogram of the given metric to an OTLP histogram.
+// counts must hold one more entry than bounds, the last one being the +Inf bucket.
+// an mtype=counter tag yields cumulative temporality, other mtypes delta. unknown mtypes are an error.
+// ts has precision p.
+func ToOTLPHistogram(key string, bounds []float64, counts []uint64, sum float64, ts int64, p Precision) (OTLPMetric, error) {
+	if len(counts) != len(bounds)+1 {
+		return OTLPMetric{}, errBucketCounts
+	}
+	name, unit, mtype, attrs, err := otlpSplit(key)
+	if err != nil {
+		return OTLPMetric{}, err
+	}
+	ns, err := otlpNanos(ts, p)
+	if err != nil {
+		return OTLPMetric{}, err
+	}
+	dp := OTLPHistogramDataPoint{
+		Attributes:     attrs,
+		TimeUnixNano:   ns,
+		Sum:            sum,
+		BucketCounts:   make([]otlpUint64, len(counts)),
+		ExplicitBounds: bounds,
+	}
+	for i, c := range counts {
+		dp.BucketCounts[i] = otlpUint64(c)
+		dp.Count += otlpUint64(c)
+	}
+	temporality := OTLPTemporalityDelta
+	if mtype != "" {
+		mt, err := ParseMType(mtype)
+		if err != nil {
+			return OTLPMetric{}, err
+		}
+		if mt == MTypeCounter {
+			temporality = OTLPTemporalityCumulative
+		}
+	}
+	return OTLPMetric{
+		Name: name,
+		Unit: unit,
+		Histogram: &OTLPHistogram{
+			DataPoints:             []OTLPHistogramDataPoint{dp},
+			AggregationTemporality: temporality,
+		},
+	}, nil
+}
+
+// FromOTLP converts an OTLP metric back to points, with timestamps of precision p.
+// histogram data points result in a stat=sum and a stat=count point each, followed by a point per bucket,
+// see HistogramBucket.
+func FromOTLP(m OTLPMetric, p Precision) ([]Point, error) {
+	var points []Point
+	switch {
+	case m.Gauge != nil:
+		for _, dp := range m.Gauge.DataPoints {
+			mtype := "gauge"
+			if m.Unit == "" && len(dp.Attributes) == 0 {
+				mtype = ""
+			}
+			ts, err := otlpTs(dp.TimeUnixNano, p)
+			if err != nil {
+				return nil, err
+			}
+			points = append(points, Point{otlpJoin(m.Name, m.Unit, mtype, dp.Attributes), dp.AsDouble, ts})
+		}
+	case m.Sum != nil:
+		mtype := "count"
+		if m.Sum.AggregationTemporality == OTLPTemporalityCumulative {
+			mtype = "counter"
+		}
+		for _, dp := range m.Sum.DataPoints {
+			ts, err := otlpTs(dp.TimeUnixNano, p)
+			if err != nil {
+				return nil, err
+			}
+			points = append(points, Point{otlpJoin(m.Name, m.Unit, mtype, dp.Attributes), dp.AsDouble, ts})
+		}
+	case m.Histogram != nil:
+		mtype := "count"
+		if m.Histogram.AggregationTemporality == OTLPTemporalityCumulative {
+			mtype = "counter"
+		}
+		for _, dp := range m.Histogram.DataPoints {
+			key := otlpJoin(m.Name, m.Unit, mtype, dp.Attributes)
+			ts, err := otlpTs(dp.TimeUnixNano, p)
+			if err != nil {
+				return nil, err
+			}
+			sum, err := HistogramSum(key, Prefixes{})
+			if err != nil {
+				return nil, err
+			}
+			count, err := HistogramCount(key, Prefixes{})
+			if err != nil {
+				return nil, err
+			}
+			points = append(points, Point{sum, dp.Sum, ts}, Point{count, float64(dp.Count), ts})
+			// bucket points count the values up to and including their bound, like prometheus does
+			var cumulative uint64
+			for i, c := range dp.BucketCounts {
+				cumulative += uint64(c)
+				bound := math.Inf(1)
+				if i < len(dp.ExplicitBounds) {
+					bound = dp.ExplicitBounds[i]
+				}
+				bucket, err := HistogramBucket(key, Prefixes{}, bound)
+				if err != nil {
+					return nil, err
+				}
+				points = append(points, Point{bucket, float64(cumulative), ts})
+			}
+		}
+	default:
+		return nil, errNoOTLPData
+	}
+	if len(points) == 0 {
+		return nil, errNoDataPoints
+	}
+	return points, nil
+}
+
+// MarshalOTLP encodes the given metrics as an OTLP/JSON MetricsData document
+func MarshalOTLP(metrics []OTLPMetric) ([]byte, error) {
+	for _, m := range metrics {
+		for _, dp := range m.gaugeOrSumPoints() {
+			if math.IsNaN(dp.AsDouble) || math.IsInf(dp.AsDouble, 0) {
+				return nil, fmt.Errorf("metric %q: cannot encode %v as OTLP/JSON", m.Name, dp.AsDouble)
+			}
+		}
+	}
+	data := otlpMetricsData{
+		ResourceMetrics: []otlpResourceMetrics{{
+			ScopeMetrics: []otlpScopeMetrics{{
+				Scope:   otlpScope{Name: otlpScopeName},
+				Metrics: metrics,
+			}},
+		}},
+	}
+	return json.Marshal(data)
+}
+
+// UnmarshalOTLP decodes an OTLP/JSON MetricsData document and returns all metrics it contains
+func UnmarshalOTLP(buf []byte) ([]OTLPMetric, error) {
+	var data otlpMetricsData
+	err := json.Unmarshal(buf, &data)
+	if err != nil {
+		return nil, err
+	}
+	var metrics []OTLPMetric
+	for _, rm := range data.ResourceMetrics {
+		for _, sm := range rm.ScopeMetrics {
+			metrics = append(metrics, sm.Metrics...)
+		}
+	}
+	return metrics, nil
+}
+
+func (m OTLPMetric) gaugeOrSumPoints() []OTLPNumberDataPoint {
+	if m.Gauge != nil {
+		return m.Gauge.DataPoints
+	}
+	if m.Sum != nil {
+		return m.Sum.DataPoints
+	}
+	return nil
+}
@@ -0,0 +1,220 @@
+package carbon20
+
+import (
+	"testing"
+
+	"github.com/bmizerany/assert"
+)
+
+func TestOTLPRoundTrip(t *testing.T) {
+	cases := []struct {
+		in          string
+		gauge       bool
+		temporality OTLPTemporality
+		monotonic   bool
+		out         string
+	}{
+		{"foo.bar", true, OTLPTemporalityUnspecified, false, "foo.bar"},
+		{"what=load.host=a.unit=Load.mtype=gauge", true, OTLPTemporalityUnspecified, false, "what=load.host=a.unit=Load.mtype=gauge"},
+		{"what=rx.unit=Bps.mtype=rate", true, OTLPTemporalityUnspecified, false, "what=rx.unit=Bps.mtype=gauge"},
+		{"what=req.unit=Req.mtype=count", false, OTLPTemporalityDelta, false, "what=req.unit=Req.mtype=count"},
+		{"what=rx.unit=B.mtype=counter", false, OTLPTemporalityCumulative, true, "what=rx.unit=B.mtype=counter"},
+		{"what_is_rx.unit_is_B.mtype_is_counter", false, OTLPTemporalityCumulative, true, "what=rx.unit=B.mtype=counter"},
+		{"mtype=count.foo.unit=Err.host=b", false, OTLPTemporalityDelta, false, "foo.host=b.unit=Err.mtype=count"},
+	}
+	for i, c := range cases {
+		m, err := ToOTLP(c.in, 12.5, 1234567890, PrecisionSecond)
+		if err != nil {
+			t.Fatalf("case %d: ToOTLP(%q): %s", i, c.in, err)
+		}
+		assert.Equal(t, c.gauge, m.Gauge != nil)
+		if !c.gauge {
+			assert.Equal(t, c.temporality, m.Sum.AggregationTemporality)
+			assert.Equal(t, c.monotonic, m.Sum.IsMonotonic)
+		}
+		buf, err := MarshalOTLP([]OTLPMetric{m})
+		if err != nil {
+			t.Fatalf("case %d: MarshalOTLP: %s", i, err)
+		}
+		metrics, err := UnmarshalOTLP(buf)
+		if err != nil {
+			t.Fatalf("case %d: UnmarshalOTLP(%s): %s", i, buf, err)
+		}
+		assert.Equal(t, 1, len(metrics))
+		points, err := FromOTLP(metrics[0], PrecisionSecond)
+		if err != nil {
+			t.Fatalf("case %d: FromOTLP: %s", i, err)
+		}
+		assert.Equal(t, []Point{{c.out, 12.5, 1234567890}}, points)
+	}
+}
+
+func TestOTLPUnits(t *testing.T) {
+	cases := []struct {
+		in   string
+		ucum string
+		out  string
+	}{
+		{"what=rx.unit=MiBps.mtype=gauge", "MiBy/s", "what=rx.unit=MiBps.mtype=gauge"},
+		{"what=rx.unit=Pckt.mtype=gauge", "{packets}", "what=rx.unit=Pckt.mtype=gauge"},
+		{"what=rx.unit=kReq.mtype=gauge", "{kReq}", "what=rx.unit=kReq.mtype=gauge"},
+		{"what=rx.unit=yes.mtype=gauge", "{yes}", "what=rx.unit=yes.mtype=gauge"},
+		{"what=rx.ucum_unit=kg_2em_2fs2.unit=Unknown.mtype=gauge", "kg.m/s2", "what=rx.ucum_unit=kg_2em_2fs2.unit=Unknown.mtype=gauge"},
+		{"what=rx.ucum_unit=_7bfoo_20bar_7d.unit=Unknown.mtype=gauge", "{foo bar}", "what=rx.ucum_unit=_7bfoo_20bar_7d.unit=Unknown.mtype=gauge"},
+	}
+	for _, c := range cases {
+		m, err := ToOTLP(c.in, 1, 1, PrecisionSecond)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, c.ucum, m.Unit)
+		points, err := FromOTLP(m, PrecisionSecond)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, c.out, points[0].Key)
+		assert.Equal(t, nil, ValidateKeyM20(c.out, MediumM20))
+		if tag, ok := GetTag(c.out, UCUMTagKey); ok {
+			assert.Equal(t, nil, validateSensibleChars(tag))
+		}
+		assert.Equal(t, ValidateVocabularyM20(c.in) == nil, ValidateVocabularyM20(c.out) == nil)
+	}
+	_, err := ToOTLP("what=rx.ucum_unit=kg_2.unit=Unknown.mtype=gauge", 1, 1, PrecisionSecond)
+	if err == nil {
+		t.Fatal("expected error for badly escaped ucum unit")
+	}
+}
+
+func TestOTLPUnknownMType(t *testing.T) {
+	_, err := ToOTLP("what=foo.unit=B.mtype=bogus", 1, 1, PrecisionSecond)
+	if err == nil {
+		t.Fatal("expected error for unknown mtype")
+	}
+	_, err = ToOTLPHistogram("what=foo.unit=B.mtype=bogus", nil, []uint64{1}, 1, 1, PrecisionSecond)
+	if err == nil {
+		t.Fatal("expected error for unknown mtype")
+	}
+}
+
+func TestOTLPName(t *testing.T) {
+	m, err := ToOTLP("what=req.unit=Req.mtype=count", 1, 1, PrecisionSecond)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "req", m.Name)
+	m, err = ToOTLP("foo.what=req.unit=Req.mtype=count", 1, 1, PrecisionSecond)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "foo", m.Name)
+	_, err = ToOTLP("host=a.unit=Req.mtype=count", 1, 1, PrecisionSecond)
+	assert.Equal(t, errNoOTLPName, err)
+}
+
+func TestOTLPAttributeKeys(t *testing.T) {
+	val, other := "api", "a=b_is_c"
+	m := OTLPMetric{
+		Name: "req",
+		Unit: "{requests}",
+		Sum: &OTLPSum{
+			DataPoints: []OTLPNumberDataPoint{{
+				Attributes: []OTLPKeyValue{
+					{"service.name", OTLPAnyValue{StringValue: &val}},
+					{"x=y", OTLPAnyValue{StringValue: &other}},
+				},
+				TimeUnixNano: 1e9,
+			}},
+			AggregationTemporality: OTLPTemporalityDelta,
+		},
+	}
+	points, err := FromOTLP(m, PrecisionSecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := "req.service_name=api.x_y=a_b-is-c.unit=Req.mtype=count"
+	assert.Equal(t, key, points[0].Key)
+	assert.Equal(t, nil, ValidateKeyM20(key, MediumM20))
+
+	// reserved keys are renamed, and empty values are left out
+	unit, mtype, ucum, none := "foo", "bar", "kg", ""
+	m.Sum.DataPoints[0].Attributes = []OTLPKeyValue{
+		{"unit", OTLPAnyValue{StringValue: &unit}},
+		{"mtype", OTLPAnyValue{StringValue: &mtype}},
+		{"ucum_unit", OTLPAnyValue{StringValue: &ucum}},
+		{"host", OTLPAnyValue{StringValue: &none}},
+		{"", OTLPAnyValue{StringValue: &val}},
+	}
+	points, err = FromOTLP(m, PrecisionSecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key = "req.orig_unit=foo.orig_mtype=bar.orig_ucum_unit=kg.unit=Req.mtype=count"
+	assert.Equal(t, key, points[0].Key)
+	assert.Equal(t, nil, ValidateKeyM20(key, MediumM20))
+	assert.Equal(t, nil, ValidateVocabularyM20(key))
+}
+
+func TestOTLPTimestamps(t *testing.T) {
+	m, err := ToOTLP("what=req.unit=Req.mtype=count", 1, 5000000010123456789, PrecisionNano)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, otlpUint64(5000000010123456789), m.Sum.DataPoints[0].TimeUnixNano)
+	points, err := FromOTLP(m, PrecisionNano)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, int64(5000000010123456789), points[0].Ts)
+	points, err = FromOTLP(m, PrecisionMilli)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, int64(5000000010123), points[0].Ts)
+
+	_, err = ToOTLP("what=req.unit=Req.mtype=count", 1, -10, PrecisionSecond)
+	assert.Equal(t, errOTLPNegativeTs, err)
+	_, err = ToOTLP("what=req.unit=Req.mtype=count", 1, 1<<62, PrecisionSecond)
+	assert.Equal(t, errTsOverflow, err)
+	m.Sum.DataPoints[0].TimeUnixNano = 1 << 63
+	_, err = FromOTLP(m, PrecisionSecond)
+	assert.Equal(t, errTsOverflow, err)
+}
+
+func TestOTLPJSON(t *testing.T) {
+	m, err := ToOTLP("what=req.unit=Req.mtype=counter", 3, 10, PrecisionSecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf, err := MarshalOTLP([]OTLPMetric{m})
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := `{"resourceMetrics":[{"scopeMetrics":[{"scope":{"name":"github.com/metrics20/go-metrics20/carbon20"},"metrics":[{"name":"req","unit":"{requests}","sum":{"dataPoints":[{"attributes":[{"key":"what","value":{"stringValue":"req"}}],"timeUnixNano":"10000000000","asDouble":3}],"aggregationTemporality":2,"isMonotonic":true}}]}]}]}`
+	assert.Equal(t, exp, string(buf))
+
+	// other producers may encode uint64 as numbers and use non-string attributes
+	in := `{"resourceMetrics":[{"scopeMetrics":[{"metrics":[{"name":"lat","unit":"ms","gauge":{"dataPoints":[{"attributes":[{"key":"shard","value":{"intValue":"3"}},{"key":"ratio","value":{"doubleValue":0.5}}],"timeUnixNano":20000000000,"asDouble":7}]}}]}]}]}`
+	metrics, err := UnmarshalOTLP([]byte(in))
+	if err != nil {
+		t.Fatal(err)
+	}
+	points, err := FromOTLP(metrics[0], PrecisionSecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, []Point{{"lat.shard=3.ratio=0_5.unit=ms.mtype=gauge", 7, 20}}, points)
+}
+
+func TestOTLPHistogram(t *testing.T) {
+	_, err := ToOTLPHistogram("what=lat.unit=ms.mtype=gauge", []float64{1, 5}, []uint64{1, 2}, 4, 10, PrecisionSecond)
+	assert.Equal(t, errBucketCounts, err)
+
+	m, err := ToOTLPHistogram("what=lat.unit=ms.mtype=gauge", []float64{1, 5}, []uint64{1, 2, 3}, 40, 10, PrecisionSecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, OTLPTemporalityDelta, m.Histogram.AggregationTemporality)
+	assert.Equal(t, otlpUint64(6), m.Histogram.DataPoints[0].Count)
+	points, err := FromOTLP(m, PrecisionSecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := []Point{
+		{"what=lat.unit=ms.mtype=count.stat=sum", 40, 10},
+		{"what=lat.unit=ms.mtype=count.stat=count", 6, 10},
+		{"what=lat.unit=ms.mtype=count.le=1", 1, 10},
+		{"what=lat.unit=ms.mtype=count.le=5", 3, 10},
+		{"what=lat.unit=ms.mtype=count.le=inf", 6, 10},
+	}
+	assert.Equal(t, exp, points)
+}
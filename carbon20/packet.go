@@ -0,0 +1,289 @@
+package carbon20
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var errWhitespace = errors.New("fields must be separated by a single space")
+var errTab = errors.New("tab in packet")
+var errInvalidPrecision = errors.New("invalid timestamp precision")
+
+var errFmtVersionNotAllowed = "metric version %s not allowed"
+
+// Normalization is a set of changes made to a carbon message to normalize it
+type Normalization int
+
+const (
+	NormalizeLeadingDots  Normalization = 1 << iota // strip leading dots from the key
+	NormalizeTrailingDots                           // strip trailing dots from the key
+	NormalizeDoubleDots                             // collapse runs of dots in the key into one, like graphite does
+	NormalizeWhitespace                             // fields separated by something else than a single space. only reported, never applied
+)
+
+var normalizationNames = []struct {
+	n    Normalization
+	name string
+}{
+	{NormalizeLeadingDots, "leading_dots"},
+	{NormalizeTrailingDots, "trailing_dots"},
+	{NormalizeDoubleDots, "double_dots"},
+	{NormalizeWhitespace, "whitespace"},
+}
+
+// String returns the names of the normalizations in the set, comma separated
+func (n Normalization) String() string {
+	var names []string
+	for _, nn := range normalizationNames {
+		if n&nn.n != 0 {
+			names = append(names, nn.name)
+		}
+	}
+	return strings.Join(names, ",")
+}
+
+// PacketOptions configures a PacketParser. The zero value validates keys with StrictLegacy and StrictM20,
+// and otherwise behaves like ValidatePacket.
+type PacketOptions struct {
+	LevelLegacy ValidationLevelLegacy
+	LevelM20    ValidationLevelM20
+
+	// Vocabulary also requires the unit and mtype of metrics 2.0 metrics to be in the vocabulary,
+	// see ValidateVocabularyM20
+	Vocabulary bool
+
+	// graphite allows a leading dot by pretending it's not there, and so do we, unless KeepLeadingDot is set.
+	// see https://github.com/grafana/metrictank/issues/668 and
+	// https://github.com/grafana/metrictank/issues/694
+	KeepLeadingDot bool
+
+	// Normalize lists the normalizations to apply to the key, before it is validated.
+	// NormalizeLeadingDots strips all leading dots, rather than one.
+	// Packet.Normalized reports which of them actually changed the key.
+	Normalize Normalization
+
+	// StrictWhitespace requires the fields to be separated by a single space, with no other whitespace
+	// than an optional trailing newline. By default, any amount of whitespace is accepted.
+	StrictWhitespace bool
+
+	// RejectTabs rejects messages with a tab anywhere, even if StrictWhitespace is not set
+	RejectTabs bool
+
+	// metric versions to reject
+	RejectLegacy      bool
+	RejectM20         bool
+	RejectM20NoEquals bool
+
+	// ParseTags fills in Packet.Tags
+	ParseTags bool
+
+	Timestamp TimestampOptions
+	Value     ValuePolicy
+}
+
+// Packet is a parsed carbon message
+type Packet struct {
+	Key     []byte // points into the parsed buffer
+	Value   Value
+	Ts      int64 // in the precision of the parser's TimestampOptions
+	Version metricVersion
+	Tags    []Tag // only set with the ParseTags option. for legacy metrics, these are the tags of the graphite tag appendix, if any
+
+	// Normalized reports the normalizations that changed the message, including the stripping of a single
+	// leading dot as NormalizeLeadingDots. NormalizeWhitespace is reported, but only used to count such messages.
+	Normalized Normalization
+}
+
+// PacketParser parses carbon messages. It is safe for concurrent use.
+type PacketParser struct {
+	opts PacketOptions
+}
+
+// NewPacketParser returns a parser with the given options
+func NewPacketParser(opts PacketOptions) (*PacketParser, error) {
+	if opts.Timestamp.Precision < PrecisionSecond || opts.Timestamp.Precision > PrecisionNano {
+		return nil, errInvalidPrecision
+	}
+	return &PacketParser{opts: opts}, nil
+}
+
+// Parse parses and validates a carbon message.
+// on error, the returned packet has the key set if the message could be split into fields.
+// with NormalizeDoubleDots, buf may be modified.
+func (p *PacketParser) Parse(buf []byte) (Packet, error) {
+	if p.opts.RejectTabs && bytes.IndexByte(buf, '\t') >= 0 {
+		return Packet{Key: empty}, errTab
+	}
+	var fields [][]byte
+	if p.opts.StrictWhitespace {
+		buf = bytes.TrimSuffix(buf, []byte("\n"))
+		fields = bytes.Split(buf, space)
+		for _, f := range fields {
+			if len(f) == 0 || bytes.IndexAny(f, " \t\r\n\v\f") >= 0 {
+				return Packet{Key: empty}, errWhitespace
+			}
+		}
+	} else {
+		fields = bytes.Fields(buf)
+	}
+	if len(fields) != 3 {
+		return Packet{Key: empty}, errWrongNumFields
+	}
+
+	var pkt Packet
+	if !p.opts.StrictWhitespace && !singleSpaced(buf, fields) {
+		pkt.Normalized |= NormalizeWhitespace
+	}
+	pkt.Key = p.normalizeKey(fields[0], &pkt.Normalized)
+	pkt.Version = GetVersionB(pkt.Key)
+	if p.rejects(pkt.Version) {
+		return pkt, fmt.Errorf(errFmtVersionNotAllowed, pkt.Version)
+	}
+	err := validateKeyB(pkt.Key, pkt.Version, p.opts.LevelLegacy, p.opts.LevelM20, p.opts.Vocabulary)
+	if err != nil {
+		return pkt, err
+	}
+
+	val, err := ParseValue(fields[1])
+	if err == nil {
+		val, err = p.opts.Value.Check(val)
+	}
+	if err != nil {
+		return pkt, err
+	}
+	pkt.Value = val
+
+	pkt.Ts, err = ParseTimestamp(fields[2], p.opts.Timestamp)
+	if err != nil {
+		return pkt, err
+	}
+
+	if p.opts.ParseTags {
+		pkt.Tags = packetTags(pkt.Key, pkt.Version)
+	}
+	return pkt, nil
+}
+
+// rejects returns whether metrics of version ver are rejected
+func (p *PacketParser) rejects(ver metricVersion) bool {
+	switch ver {
+	case Legacy:
+		return p.opts.RejectLegacy
+	case M20:
+		return p.opts.RejectM20
+	case M20NoEquals:
+		return p.opts.RejectM20NoEquals
+	}
+	return false
+}
+
+// validateKeyB validates key, which has version ver as reported by GetVersionB, at the given levels.
+// with vocab, metrics 2.0 keys must also pass ValidateVocabularyM20B.
+func validateKeyB(key []byte, ver metricVersion, levelLegacy ValidationLevelLegacy, levelM20 ValidationLevelM20, vocab bool) error {
+	var err error
+	switch ver {
+	case Legacy:
+		return ValidateKeyLegacyB(key, levelLegacy)
+	case M20:
+		err = ValidateKeyM20B(key, levelM20)
+	case M20NoEquals:
+		err = ValidateKeyM20NoEqualsB(key, levelM20)
+	}
+	if err == nil && vocab {
+		err = ValidateVocabularyM20B(key)
+	}
+	return err
+}
+
+// normalizeKey applies the normalizations to key, and adds the ones that changed it to done
+func (p *PacketParser) normalizeKey(key []byte, done *Normalization) []byte {
+	if p.opts.Normalize&NormalizeLeadingDots != 0 {
+		trimmed := bytes.TrimLeft(key, ".")
+		if len(trimmed) != len(key) {
+			*done |= NormalizeLeadingDots
+		}
+		key = trimmed
+	} else if !p.opts.KeepLeadingDot && len(key) != 0 && key[0] == '.' {
+		*done |= NormalizeLeadingDots
+		key = key[1:]
+	}
+	if p.opts.Normalize&NormalizeTrailingDots != 0 {
+		trimmed := bytes.TrimRight(key, ".")
+		if len(trimmed) != len(key) {
+			*done |= NormalizeTrailingDots
+		}
+		key = trimmed
+	}
+	if p.opts.Normalize&NormalizeDoubleDots != 0 && bytes.Contains(key, doubleDot) {
+		*done |= NormalizeDoubleDots
+		n := 0
+		for i, c := range key {
+			if c == '.' && i > 0 && key[i-1] == '.' {
+				continue
+			}
+			key[n] = c
+			n++
+		}
+		key = key[:n]
+	}
+	return key
+}
+
+// singleSpaced returns whether the fields of buf are separated by single spaces,
+// with no other whitespace than an optional trailing newline
+func singleSpaced(buf []byte, fields [][]byte) bool {
+	buf = bytes.TrimSuffix(buf, []byte("\n"))
+	n := len(fields) - 1
+	for _, f := range fields {
+		n += len(f)
+	}
+	if n != len(buf) || len(fields) == 0 || &buf[0] != &fields[0][0] {
+		return false
+	}
+	for _, f := range fields[:len(fields)-1] {
+		if buf[len(f)] != ' ' {
+			return false
+		}
+		buf = buf[len(f)+1:]
+	}
+	return true
+}
+
+// packetTags returns the tags of a metric key: the metrics 2.0 tags, or the graphite tag appendix of a legacy key
+func packetTags(key []byte, ver metricVersion) []Tag {
+	if ver != Legacy {
+		return ParseTags(string(key))
+	}
+	i := bytes.IndexByte(key, ';')
+	if i < 0 {
+		return nil
+	}
+	var tags []Tag
+	for _, t := range bytes.Split(key[i+1:], []byte(";")) {
+		k, v, _ := splitTag(string(t), "=")
+		tags = append(tags, Tag{k, v})
+	}
+	return tags
+}
+
+// AppendPacket appends a carbon message with the given key, value and timestamp to dst, including the trailing newline.
+// ts has precision p, and is written in seconds, see AppendTimestamp.
+// the value is checked against vp. if it is rejected, or p is unknown, dst is returned unchanged along with the error.
+func AppendPacket(dst, key []byte, val Value, ts int64, p Precision, vp ValuePolicy) ([]byte, error) {
+	val, err := vp.Check(val)
+	if err != nil {
+		return dst, err
+	}
+	n := len(dst)
+	dst = append(dst, key...)
+	dst = append(dst, ' ')
+	dst = val.AppendTo(dst)
+	dst = append(dst, ' ')
+	dst, err = AppendTimestamp(dst, ts, p)
+	if err != nil {
+		return dst[:n], err
+	}
+	return append(dst, '\n'), nil
+}
@@ -0,0 +1,170 @@
+package carbon20
+
+import (
+	"testing"
+
+	"github.com/bmizerany/assert"
+)
+
+func TestPacketParser(t *testing.T) {
+	p, err := NewPacketParser(PacketOptions{
+		LevelLegacy: MediumLegacy,
+		LevelM20:    MediumM20,
+		ParseTags:   true,
+		Timestamp:   TimestampOptions{Precision: PrecisionMilli},
+	})
+	assert.Equal(t, nil, err)
+
+	pkt, err := p.Parse([]byte("what=rx.unit=B.mtype=counter 18446744073709551615 1234567890.5\n"))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "what=rx.unit=B.mtype=counter", string(pkt.Key))
+	assert.Equal(t, UintValue(18446744073709551615), pkt.Value)
+	assert.Equal(t, int64(1234567890500), pkt.Ts)
+	assert.Equal(t, M20, pkt.Version)
+	assert.Equal(t, []Tag{{"what", "rx"}, {"unit", "B"}, {"mtype", "counter"}}, pkt.Tags)
+
+	pkt, err = p.Parse([]byte(".foo.bar;dc=ams;host=a  1\t1234567890"))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "foo.bar;dc=ams;host=a", string(pkt.Key))
+	assert.Equal(t, Legacy, pkt.Version)
+	assert.Equal(t, []Tag{{"dc", "ams"}, {"host", "a"}}, pkt.Tags)
+
+	pkt, err = p.Parse([]byte("foo.bar 1 1234567890"))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 0, len(pkt.Tags))
+}
+
+func TestPacketParserKeepsIntegers(t *testing.T) {
+	p, err := NewPacketParser(PacketOptions{LevelLegacy: MediumLegacy, LevelM20: MediumM20})
+	assert.Equal(t, nil, err)
+	pkt, err := p.Parse([]byte("if.rx_bytes 18446744073709551000 1234567890"))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "if.rx_bytes", string(pkt.Key))
+	u, ok := pkt.Value.Uint64()
+	assert.Equal(t, true, ok)
+	assert.Equal(t, uint64(18446744073709551000), u)
+	assert.Equal(t, int64(1234567890), pkt.Ts)
+
+	_, fval, _, err := ValidatePacket([]byte("if.rx_bytes 18446744073709551000 1234567890"), MediumLegacy, MediumM20)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, pkt.Value.Float64(), fval)
+}
+
+func TestAppendPacket(t *testing.T) {
+	p, err := NewPacketParser(PacketOptions{
+		LevelLegacy: MediumLegacy,
+		LevelM20:    MediumM20,
+		Timestamp:   TimestampOptions{Precision: PrecisionMilli},
+	})
+	assert.Equal(t, nil, err)
+	for _, in := range []string{
+		"foo.bar 9007199254740993 1234567890\n",
+		"foo.bar -12 1\n",
+		"foo.bar 0.125 1234567890\n",
+		"foo.bar 0.125 1234567890.25\n",
+	} {
+		pkt, err := p.Parse([]byte(in))
+		assert.Equal(t, nil, err)
+		out, err := AppendPacket(nil, pkt.Key, pkt.Value, pkt.Ts, PrecisionMilli, ValuePolicy{})
+		assert.Equal(t, nil, err)
+		assert.Equal(t, in, string(out))
+	}
+	out, err := AppendPacket([]byte("x"), []byte("foo.bar"), IntValue(1), 1, Precision(9), ValuePolicy{})
+	assert.Equal(t, "unknown precision 9", err.Error())
+	assert.Equal(t, "x", string(out))
+}
+
+func TestPacketParserOptions(t *testing.T) {
+	cases := []struct {
+		opts PacketOptions
+		in   string
+		err  string
+	}{
+		{PacketOptions{}, "foo.bar 1 1", ""},
+		{PacketOptions{}, "foo..bar 1 1", "empty node"},
+		{PacketOptions{}, "what=rx.unit=yes.mtype=gauge 1 1", ""},
+		{PacketOptions{Vocabulary: true}, "what=rx.unit=yes.mtype=gauge 1 1", `unknown unit "yes"`},
+		{PacketOptions{Vocabulary: true}, "what=rx.unit=B.mtype=bogus 1 1", `unknown mtype: "bogus"`},
+		{PacketOptions{Vocabulary: true}, "foo.bytes 1 1", ""},
+		{PacketOptions{StrictWhitespace: true}, "foo.bar 1 1\n", ""},
+		{PacketOptions{StrictWhitespace: true}, "foo.bar  1 1", "fields must be separated by a single space"},
+		{PacketOptions{StrictWhitespace: true}, "foo.bar\t1 1", "fields must be separated by a single space"},
+		{PacketOptions{StrictWhitespace: true}, "foo.bar 1 1\r\n", "fields must be separated by a single space"},
+		{PacketOptions{StrictWhitespace: true}, "foo.bar 1", "packet must consist of 3 fields"},
+		{PacketOptions{RejectLegacy: true}, "foo.bar 1 1", "metric version Legacy not allowed"},
+		{PacketOptions{RejectM20NoEquals: true, LevelM20: NoneM20}, "foo.bar 1 1", ""},
+		{PacketOptions{RejectM20NoEquals: true, LevelM20: NoneM20}, "what_is_rx.unit_is_B 1 1", "metric version M20NoEquals not allowed"},
+		{PacketOptions{Value: ValuePolicy{NonFinite: NonFiniteReject}}, "foo.bar NaN 1", "value is NaN or infinite"},
+		{PacketOptions{Timestamp: TimestampOptions{Policy: TimestampPolicy{RejectNonPositive: true}}}, "foo.bar 1 0", "timestamp must be positive"},
+	}
+	for i, c := range cases {
+		p, err := NewPacketParser(c.opts)
+		assert.Equal(t, nil, err)
+		_, err = p.Parse([]byte(c.in))
+		got := ""
+		if err != nil {
+			got = err.Error()
+		}
+		if got != c.err {
+			t.Fatalf("case %d: Parse(%q): expected error %q, got %q", i, c.in, c.err, got)
+		}
+	}
+
+	p, _ := NewPacketParser(PacketOptions{KeepLeadingDot: true})
+	pkt, err := p.Parse([]byte(".foo.bar 1 1"))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, ".foo.bar", string(pkt.Key))
+
+	_, err = NewPacketParser(PacketOptions{Timestamp: TimestampOptions{Precision: 9}})
+	assert.Equal(t, errInvalidPrecision, err)
+}
+
+func BenchmarkPacketParser(b *testing.B) {
+	p, _ := NewPacketParser(PacketOptions{LevelLegacy: MediumLegacy, LevelM20: MediumM20})
+	in := []byte("carbon.agents.foo.cache.overflow 123.456 1234567890")
+	for i := 0; i < b.N; i++ {
+		_, err := p.Parse(in)
+		if err != nil {
+			panic(err)
+		}
+	}
+}
+
+func TestPacketNormalization(t *testing.T) {
+	all := NormalizeLeadingDots | NormalizeTrailingDots | NormalizeDoubleDots
+	cases := []struct {
+		opts PacketOptions
+		in   string
+		key  string
+		done Normalization
+	}{
+		{PacketOptions{}, "foo.bar 1 1\n", "foo.bar", 0},
+		{PacketOptions{}, ".foo.bar 1 1", "foo.bar", NormalizeLeadingDots},
+		{PacketOptions{}, "foo.bar  1\t1", "foo.bar", NormalizeWhitespace},
+		{PacketOptions{}, " foo.bar 1 1", "foo.bar", NormalizeWhitespace},
+		{PacketOptions{}, "foo.bar 1 1\r\n", "foo.bar", NormalizeWhitespace},
+		{PacketOptions{KeepLeadingDot: true, LevelLegacy: NoneLegacy}, ".foo.bar 1 1", ".foo.bar", 0},
+		{PacketOptions{Normalize: all}, "..foo..bar...baz. 1 1", "foo.bar.baz", all},
+		{PacketOptions{Normalize: NormalizeTrailingDots}, "foo.bar.. 1 1", "foo.bar", NormalizeTrailingDots},
+		{PacketOptions{Normalize: NormalizeDoubleDots}, "foo..bar 1 1", "foo.bar", NormalizeDoubleDots},
+		{PacketOptions{Normalize: NormalizeDoubleDots, LevelM20: MediumM20}, "what=rx..unit=B.mtype=gauge 1 1", "what=rx.unit=B.mtype=gauge", NormalizeDoubleDots},
+		{PacketOptions{LevelM20: MediumM20}, ".what=rx.unit=B.mtype=gauge 1 1", "what=rx.unit=B.mtype=gauge", NormalizeLeadingDots},
+	}
+	for i, c := range cases {
+		p, err := NewPacketParser(c.opts)
+		assert.Equal(t, nil, err)
+		pkt, err := p.Parse([]byte(c.in))
+		if err != nil {
+			t.Fatalf("case %d: Parse(%q): %s", i, c.in, err)
+		}
+		assert.Equal(t, c.key, string(pkt.Key))
+		if pkt.Normalized != c.done {
+			t.Fatalf("case %d: Parse(%q): expected normalizations %q, got %q", i, c.in, c.done, pkt.Normalized)
+		}
+	}
+
+	p, _ := NewPacketParser(PacketOptions{RejectTabs: true})
+	_, err := p.Parse([]byte("foo.bar 1\t1"))
+	assert.Equal(t, errTab, err)
+	assert.Equal(t, "leading_dots,whitespace", (NormalizeLeadingDots | NormalizeWhitespace).String())
+}
@@ -0,0 +1,109 @@
+package carbon20
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var errPrefixReservedKey = errors.New("prefix tags can't set unit or mtype")
+var errPrefixTagDot = errors.New("prefix tag keys and values can't contain dots")
+
+var errFmtInvalidPrefixTag = "invalid prefix tag %q: %s"
+
+// Prefixes holds the prefixes to apply to the output of an operation, for each metric version.
+// For metrics 2.0 the prefix is a set of tags, which is rendered in the style of the metric.
+// Use NewPrefixes or ParsePrefixes to create one; the zero value has no prefixes.
+type Prefixes struct {
+	tags   []Tag
+	legacy string // e.g. "prefix."
+	m20    string // e.g. "env=prod.dc=ams."
+	m20ne  string // e.g. "env_is_prod.dc_is_ams."
+}
+
+// NewPrefixes validates and returns prefixes using the given legacy prefix and metrics 2.0 tags.
+// the legacy prefix gets a trailing dot, if it doesn't have one.
+func NewPrefixes(legacy string, tags ...Tag) (Prefixes, error) {
+	var p Prefixes
+	if legacy != "" {
+		if !strings.HasSuffix(legacy, ".") {
+			legacy += "."
+		}
+		err := ValidateKeyLegacy(legacy[:len(legacy)-1], StrictLegacy)
+		if err != nil {
+			return p, fmt.Errorf("invalid legacy prefix %q: %s", legacy, err)
+		}
+	}
+	var m20, m20ne []string
+	for _, tag := range tags {
+		err := validatePrefixTag(tag)
+		if err != nil {
+			return p, fmt.Errorf(errFmtInvalidPrefixTag, tag.Key+"="+tag.Value, err)
+		}
+		m20 = append(m20, tag.Key+"="+tag.Value+".")
+		m20ne = append(m20ne, tag.Key+"_is_"+tag.Value+".")
+	}
+	p.legacy = legacy
+	p.tags = append([]Tag(nil), tags...)
+	p.m20 = strings.Join(m20, "")
+	p.m20ne = strings.Join(m20ne, "")
+	return p, nil
+}
+
+// ParsePrefixes is like NewPrefixes, but takes the tags as a metrics 2.0 string, e.g. "env=prod.dc=ams".
+// both the = and the _is_ style are accepted.
+func ParsePrefixes(legacy, tags string) (Prefixes, error) {
+	tags = strings.TrimSuffix(tags, ".")
+	if tags == "" {
+		return NewPrefixes(legacy)
+	}
+	sep := tagSep(GetVersion(tags))
+	var parsed []Tag
+	for _, node := range strings.Split(tags, ".") {
+		k, v, ok := splitTag(node, sep)
+		if !ok {
+			return Prefixes{}, fmt.Errorf(errFmtInvalidPrefixTag, node, errKeyOrValEmpty)
+		}
+		parsed = append(parsed, Tag{k, v})
+	}
+	return NewPrefixes(legacy, parsed...)
+}
+
+// validatePrefixTag checks that a tag can be safely rendered in both metrics 2.0 styles
+func validatePrefixTag(tag Tag) error {
+	if tag.Key == "" || tag.Value == "" {
+		return errKeyOrValEmpty
+	}
+	if tag.Key == "unit" || tag.Key == "mtype" {
+		return errPrefixReservedKey
+	}
+	for _, s := range []string{tag.Key, tag.Value} {
+		if strings.Contains(s, "_is_") {
+			return errMixEqualsTypes
+		}
+		if strings.Contains(s, ".") {
+			return errPrefixTagDot
+		}
+		err := validateSensibleChars(s)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Tags returns the metrics 2.0 prefix tags
+func (p Prefixes) Tags() []Tag {
+	return append([]Tag(nil), p.tags...)
+}
+
+// For returns the rendered prefix for a metric of the given version
+func (p Prefixes) For(ver metricVersion) string {
+	switch ver {
+	case M20:
+		return p.m20
+	case M20NoEquals:
+		return p.m20ne
+	}
+	return p.legacy
+}
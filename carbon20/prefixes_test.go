@@ -0,0 +1,56 @@
+package carbon20
+
+import (
+	"testing"
+
+	"github.com/bmizerany/assert"
+)
+
+func TestPrefixes(t *testing.T) {
+	cases := []struct {
+		legacy string
+		tags   string
+		valid  bool
+		p1     string
+		p2     string
+		p2ne   string
+	}{
+		{"", "", true, "", "", ""},
+		{"prefix.", "", true, "prefix.", "", ""},
+		{"prefix", "", true, "prefix.", "", ""},
+		{"a.b", "env=prod.dc=ams", true, "a.b.", "env=prod.dc=ams.", "env_is_prod.dc_is_ams."},
+		{"", "env_is_prod.dc_is_ams.", true, "", "env=prod.dc=ams.", "env_is_prod.dc_is_ams."},
+		{"a..b", "", false, "", "", ""},
+		{"a:b", "", false, "", "", ""},
+		{"", "our=prefix.", true, "", "our=prefix.", "our_is_prefix."},
+		{"", "our=", false, "", "", ""},
+		{"", "our.prefix", false, "", "", ""},
+		{"", "unit=B", false, "", "", ""},
+		{"", "mtype=gauge", false, "", "", ""},
+		{"", "our=pre:fix", false, "", "", ""},
+	}
+	for i, c := range cases {
+		p, err := ParsePrefixes(c.legacy, c.tags)
+		if (err == nil) != c.valid {
+			t.Fatalf("case %d: ParsePrefixes(%q, %q): expected valid=%t, got err=%v", i, c.legacy, c.tags, c.valid, err)
+		}
+		if !c.valid {
+			continue
+		}
+		assert.Equal(t, c.p1, p.For(Legacy))
+		assert.Equal(t, c.p2, p.For(M20))
+		assert.Equal(t, c.p2ne, p.For(M20NoEquals))
+	}
+
+	_, err := NewPrefixes("", Tag{"our", "pre_is_fix"})
+	assert.Equal(t, `invalid prefix tag "our=pre_is_fix": both = and _is_`, err.Error())
+	_, err = NewPrefixes("", Tag{"dc", "ams.nl"})
+	assert.Equal(t, `invalid prefix tag "dc=ams.nl": prefix tag keys and values can't contain dots`, err.Error())
+
+	p, err := NewPrefixes("", Tag{"env", "prod"}, Tag{"dc", "ams"})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []Tag{{"env", "prod"}, {"dc", "ams"}}, p.Tags())
+	out, err := CountPcktTransform().Apply("what_is_rx.unit_is_B", p)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "env_is_prod.dc_is_ams.what_is_rx.unit_is_Pckt.orig_unit_is_B.pckt_type_is_sent.direction_is_in", out)
+}
@@ -0,0 +1,268 @@
+package carbon20
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+var errEmptyOpName = errors.New("operation name must be non-empty")
+var errNoTransform = errors.New("operation must have a transform")
+
+var errFmtDuplicateOp = "operation %q already registered"
+var errFmtUnknownOp = "unknown operation %q"
+var errFmtUnusedParam = "operation %q does not take parameter %s"
+
+// Param is a set of parameters that an operation takes, besides the prefixes.
+type Param int
+
+const (
+	ParamPercentile Param = 1 << iota
+	ParamTimespec
+	ParamM1Legacy
+	ParamStatPolicy
+	ParamUpperBound
+)
+
+var paramNames = []struct {
+	p    Param
+	name string
+}{
+	{ParamPercentile, "percentile"},
+	{ParamTimespec, "timespec"},
+	{ParamM1Legacy, "m1Legacy"},
+	{ParamStatPolicy, "statPolicy"},
+	{ParamUpperBound, "upperBound"},
+}
+
+// String returns the names of the parameters in the set, comma separated
+func (p Param) String() string {
+	var names []string
+	for _, pn := range paramNames {
+		if p&pn.p != 0 {
+			names = append(names, pn.name)
+		}
+	}
+	return strings.Join(names, ",")
+}
+
+// Params holds the parameters for an operation.
+// which ones are used depends on the operation, see Operation.Params
+type Params struct {
+	Prefixes   Prefixes
+	Percentile string
+	Timespec   Timespec
+	M1Legacy   bool
+	StatPolicy StatPolicy
+	UpperBound float64
+}
+
+// set returns the set of non-prefix parameters that have a non-zero value
+func (p Params) set() Param {
+	var set Param
+	if p.Percentile != "" {
+		set |= ParamPercentile
+	}
+	if !p.Timespec.IsZero() {
+		set |= ParamTimespec
+	}
+	if p.M1Legacy {
+		set |= ParamM1Legacy
+	}
+	if p.StatPolicy != StatNest {
+		set |= ParamStatPolicy
+	}
+	if p.UpperBound != 0 {
+		set |= ParamUpperBound
+	}
+	return set
+}
+
+// Operation is a named operation on metrics, that can be looked up in a Registry
+type Operation struct {
+	Name      string
+	Help      string
+	Params    Param // parameters the operation takes, besides the prefixes
+	Transform func(p Params) Transform
+}
+
+// Apply applies the operation to the given metric.
+// It returns an error for parameters the operation doesn't take, and for illegal transitions.
+func (o Operation) Apply(in string, p Params) (string, error) {
+	if unused := p.set() &^ o.Params; unused != 0 {
+		return "", fmt.Errorf(errFmtUnusedParam, o.Name, unused)
+	}
+	err := validateStatParams(p.Percentile, p.Timespec)
+	if err != nil {
+		return "", err
+	}
+	return o.Transform(p).Apply(in, p.Prefixes)
+}
+
+// Registry maps operation names to operations. It is safe for concurrent use.
+type Registry struct {
+	mu  sync.RWMutex
+	ops map[string]Operation
+}
+
+// NewRegistry returns an empty registry
+func NewRegistry() *Registry {
+	return &Registry{
+		ops: make(map[string]Operation),
+	}
+}
+
+// Register adds an operation to the registry. names must be unique.
+func (r *Registry) Register(op Operation) error {
+	if op.Name == "" {
+		return errEmptyOpName
+	}
+	if op.Transform == nil {
+		return errNoTransform
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.ops[op.Name]; ok {
+		return fmt.Errorf(errFmtDuplicateOp, op.Name)
+	}
+	r.ops[op.Name] = op
+	return nil
+}
+
+// RegisterTransform adds an operation that applies the given transform, under the transform's name.
+func (r *Registry) RegisterTransform(t Transform, help string) error {
+	return r.Register(Operation{
+		Name:      t.Name,
+		Help:      help,
+		Transform: func(Params) Transform { return t },
+	})
+}
+
+// Lookup returns the operation with the given name
+func (r *Registry) Lookup(name string) (Operation, bool) {
+	r.mu.RLock()
+	op, ok := r.ops[name]
+	r.mu.RUnlock()
+	return op, ok
+}
+
+// List returns all registered operations, sorted by name
+func (r *Registry) List() []Operation {
+	r.mu.RLock()
+	ops := make([]Operation, 0, len(r.ops))
+	for _, op := range r.ops {
+		ops = append(ops, op)
+	}
+	r.mu.RUnlock()
+	sort.Slice(ops, func(i, j int) bool { return ops[i].Name < ops[j].Name })
+	return ops
+}
+
+// Apply looks up the named operation and applies it to the given metric
+func (r *Registry) Apply(name, in string, p Params) (string, error) {
+	op, ok := r.Lookup(name)
+	if !ok {
+		return "", fmt.Errorf(errFmtUnknownOp, name)
+	}
+	return op.Apply(in, p)
+}
+
+// DefaultRegistry holds the operations provided by this package.
+// user defined operations may be added to it.
+var DefaultRegistry = newDefaultRegistry()
+
+func statOperation(legacyStat, stat, help string) Operation {
+	return Operation{
+		Name:   stat,
+		Help:   help,
+		Params: ParamPercentile | ParamTimespec | ParamStatPolicy,
+		Transform: func(p Params) Transform {
+			return StatTransform(legacyStat, stat, p.Percentile, p.Timespec, p.StatPolicy)
+		},
+	}
+}
+
+func newDefaultRegistry() *Registry {
+	r := NewRegistry()
+	ops := []Operation{
+		{
+			Name:      "derive_count",
+			Help:      "derive a count or counter into a rate per second",
+			Params:    ParamM1Legacy,
+			Transform: func(p Params) Transform { return DeriveCountTransform(p.M1Legacy) },
+		},
+		{
+			Name:      "gauge",
+			Help:      "keep the metric as is, only apply the prefix",
+			Transform: func(Params) Transform { return GaugeTransform() },
+		},
+		{
+			Name:      "count_pckt",
+			Help:      "count the amount of packets received",
+			Transform: func(Params) Transform { return CountPcktTransform() },
+		},
+		{
+			Name:      "count_metric",
+			Help:      "count how many metrics were received",
+			Transform: func(Params) Transform { return CountMetricTransform() },
+		},
+		{
+			Name:      "count",
+			Help:      "count something each interval, keeping the unit",
+			Params:    ParamM1Legacy,
+			Transform: func(p Params) Transform { return CountTransform(p.M1Legacy) },
+		},
+		{
+			Name:      "counter",
+			Help:      "count something across time, keeping the unit",
+			Transform: func(Params) Transform { return CounterTransform() },
+		},
+		{
+			Name:      "rate_pckt",
+			Help:      "rate per second of packets received",
+			Transform: func(Params) Transform { return RatePcktTransform() },
+		},
+		{
+			Name:      "integrate_rate",
+			Help:      "integrate a rate per second into a count",
+			Params:    ParamM1Legacy,
+			Transform: func(p Params) Transform { return IntegrateRateTransform(p.M1Legacy) },
+		},
+		{
+			Name:      "integrate_rate_cumulative",
+			Help:      "integrate a rate per second into a counter",
+			Transform: func(Params) Transform { return IntegrateRateCumulativeTransform() },
+		},
+		statOperation("upper", "max", "maximum value"),
+		statOperation("lower", "min", "minimum value"),
+		statOperation("mean", "mean", "mean value"),
+		statOperation("sum", "sum", "sum of values"),
+		statOperation("median", "median", "median value"),
+		statOperation("std", "std", "standard deviation"),
+		{
+			Name:      "histogram_bucket",
+			Help:      "count the values up to and including the upper bound",
+			Params:    ParamUpperBound,
+			Transform: func(p Params) Transform { return HistogramBucketTransform(p.UpperBound) },
+		},
+		{
+			Name:      "histogram_count",
+			Help:      "count the values of a histogram",
+			Transform: func(Params) Transform { return HistogramCountTransform() },
+		},
+		{
+			Name:      "histogram_sum",
+			Help:      "sum the values of a histogram",
+			Transform: func(Params) Transform { return HistogramSumTransform() },
+		},
+	}
+	for _, op := range ops {
+		err := r.Register(op)
+		if err != nil {
+			panic(err)
+		}
+	}
+	return r
+}
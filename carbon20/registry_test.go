@@ -0,0 +1,74 @@
+package carbon20
+
+import (
+	"testing"
+
+	"github.com/bmizerany/assert"
+)
+
+func TestRegistryBuiltins(t *testing.T) {
+	legacy, err := NewPrefixes("p.")
+	assert.Equal(t, nil, err)
+	m20, err := NewPrefixes("", Tag{"our", "prefix"})
+	assert.Equal(t, nil, err)
+	cases := []struct {
+		op  string
+		in  string
+		p   Params
+		out string
+	}{
+		{"derive_count", "what=rx.unit=B.mtype=counter", Params{}, "what=rx.unit=Bps.mtype=rate"},
+		{"derive_count", "foo.bar", Params{Prefixes: legacy}, "p.foo.bar.rate"},
+		{"derive_count", "foo.bar", Params{Prefixes: legacy, M1Legacy: true}, "p.foo.bar"},
+		{"max", "what=lat.unit=ms.mtype=gauge", Params{Percentile: "99", Timespec: Timespec{1, "m"}}, "what=lat.unit=ms.mtype=gauge.stat=max_99__1m"},
+		{"max", "foo.bar", Params{Percentile: "99"}, "foo.bar.upper_99"},
+		{"median", "foo.bar", Params{}, "foo.bar.median"},
+		{"max", "what=lat.unit=ms.stat=mean__1m", Params{Timespec: Timespec{1, "h"}}, "what=lat.unit=ms.stat=max__1h_of_mean__1m"},
+		{"max", "what=lat.unit=ms.stat=mean", Params{StatPolicy: StatReplace}, "what=lat.unit=ms.stat=max"},
+		{"count_pckt", "what=rx.unit=B.mtype=gauge", Params{Prefixes: m20}, "our=prefix.what=rx.unit=Pckt.mtype=count.orig_unit=B.pckt_type=sent.direction=in"},
+		{"rate_pckt", "foo.bar", Params{}, "foo.bar.count_ps"},
+	}
+	for i, c := range cases {
+		out, err := DefaultRegistry.Apply(c.op, c.in, c.p)
+		if err != nil {
+			t.Fatalf("case %d: %s", i, err)
+		}
+		assert.Equal(t, c.out, out)
+	}
+}
+
+func TestRegistryErrors(t *testing.T) {
+	_, err := DefaultRegistry.Apply("bogus", "foo.bar", Params{})
+	assert.Equal(t, `unknown operation "bogus"`, err.Error())
+	_, err = DefaultRegistry.Apply("derive_count", "foo.bar", Params{Percentile: "90", Timespec: Timespec{1, "m"}})
+	assert.Equal(t, `operation "derive_count" does not take parameter percentile,timespec`, err.Error())
+	_, err = DefaultRegistry.Apply("max", "what=lat.unit=ms.stat=mean", Params{StatPolicy: StatError})
+	assert.Equal(t, errStatConflict, err)
+	_, err = DefaultRegistry.Apply("derive_count", "what=rx.unit=B.mtype=gauge", Params{})
+	assert.Equal(t, "derive_count can't be applied to mtype=gauge", err.Error())
+	_, err = DefaultRegistry.Apply("max", "what=lat.unit=ms", Params{Percentile: "p99"})
+	assert.Equal(t, `invalid percentile "p99": want digits only`, err.Error())
+}
+
+func TestRegistryUserTransforms(t *testing.T) {
+	r := NewRegistry()
+	delta := Transform{Name: "delta", Steps: []Step{SetMType(MTypeCount)}, LegacySuffix: ".delta"}
+	assert.Equal(t, nil, r.RegisterTransform(delta, "difference between consecutive values"))
+	assert.Equal(t, `operation "delta" already registered`, r.RegisterTransform(delta, "").Error())
+	assert.Equal(t, errEmptyOpName, r.Register(Operation{Transform: func(Params) Transform { return delta }}))
+	assert.Equal(t, errNoTransform, r.Register(Operation{Name: "ratio"}))
+	out, err := r.Apply("delta", "foo.bar", Params{})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "foo.bar.delta", out)
+
+	op, ok := r.Lookup("delta")
+	assert.Equal(t, true, ok)
+	assert.Equal(t, "difference between consecutive values", op.Help)
+
+	var names []string
+	for _, op := range DefaultRegistry.List() {
+		names = append(names, op.Name)
+	}
+	exp := []string{"count", "count_metric", "count_pckt", "counter", "derive_count", "gauge", "histogram_bucket", "histogram_count", "histogram_sum", "integrate_rate", "integrate_rate_cumulative", "max", "mean", "median", "min", "rate_pckt", "std", "sum"}
+	assert.Equal(t, exp, names)
+}
@@ -0,0 +1,151 @@
+package carbon20
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var errNoInterval = errors.New("rollup interval must be non-empty")
+
+var errFmtUnknownConsolidation = "unknown consolidation function %q"
+
+// rollupFns maps the consolidation functions to the stat they produce, and its legacy name as used by simpleStat
+var rollupFns = map[string]struct{ stat, legacy string }{
+	"avg":    {"mean", "mean"},
+	"sum":    {"sum", "sum"},
+	"min":    {"min", "lower"},
+	"max":    {"max", "upper"},
+	"median": {"median", "median"},
+	"first":  {"first", "first"},
+	"last":   {"last", "last"},
+}
+
+// rollupStats is the inverse of rollupFns, for metrics 2.0 stats
+var rollupStats = make(map[string]string)
+
+// rollupLegacyStats is the inverse of rollupFns, for legacy stat nodes
+var rollupLegacyStats = make(map[string]string)
+
+func init() {
+	for fn, s := range rollupFns {
+		rollupStats[s.stat] = fn
+		rollupLegacyStats[s.legacy] = fn
+	}
+}
+
+// RollupSpec describes how a series was downsampled
+type RollupSpec struct {
+	Fn       string   // consolidation function, one of avg, sum, min, max, median, first, last
+	Interval Timespec // the interval the series was downsampled to
+}
+
+// rollupTransform returns the transform for Rollup, without validating its arguments
+func rollupTransform(stat, legacy string, interval Timespec) Transform {
+	ts := interval.String()
+	return newTransform("rollup", "."+legacy+".rollup_"+ts,
+		AddStat(stat, StatNest),
+		StackTag("rollup", ts, StatNest),
+	)
+}
+
+// Rollup returns the name of the series that results from downsampling in to the given interval,
+// using consolidationFn (see RollupSpec) to combine the points in each interval:
+// * metrics 2.0 get stat and rollup tags, e.g. stat=max.rollup=1h
+// * graphite tagged names get the same tags in graphite style, e.g. foo.bar;stat=max;rollup=1h
+// * legacy names get the legacy stat node and a rollup node, e.g. foo.bar.upper.rollup_1h
+// rolling up a series that is itself a rollup (or has a stat tag) nests the tags, e.g.
+// stat=max_of_mean.rollup=1h_of_1m, so that ParseRollup can recover the input.
+// the output is prefixed like that of the manipulate functions. graphite tagged names get the legacy prefix.
+func Rollup(in string, p Prefixes, consolidationFn string, interval Timespec) (string, error) {
+	fn, ok := rollupFns[consolidationFn]
+	if !ok {
+		return "", fmt.Errorf(errFmtUnknownConsolidation, consolidationFn)
+	}
+	if interval.IsZero() {
+		return "", errNoInterval
+	}
+	t := rollupTransform(fn.stat, fn.legacy, interval)
+	err := t.Check(in)
+	if err != nil {
+		return "", err
+	}
+	if strings.IndexByte(in, ';') >= 0 {
+		nodes := strings.Split(in, ";")
+		for _, s := range t.Steps {
+			nodes = s.apply(nodes, "=")
+		}
+		return p.legacy + strings.Join(nodes, ";"), nil
+	}
+	return t.apply(in, p), nil
+}
+
+// ParseRollup is the inverse of Rollup: it returns the series that in was rolled up from, and how.
+// ok is false if in is not a rollup. prefixes are not removed.
+func ParseRollup(in string) (raw string, spec RollupSpec, ok bool) {
+	if strings.IndexByte(in, ';') >= 0 {
+		return parseRollupNodes(strings.Split(in, ";"), "=", ";")
+	}
+	ver := GetVersion(in)
+	if ver != Legacy {
+		return parseRollupNodes(strings.Split(in, "."), tagSep(ver), ".")
+	}
+
+	nodes := strings.Split(in, ".")
+	n := len(nodes)
+	if n < 3 || !strings.HasPrefix(nodes[n-1], "rollup_") {
+		return "", RollupSpec{}, false
+	}
+	fn, ok := rollupLegacyStats[nodes[n-2]]
+	interval, err := ParseTimespec(strings.TrimPrefix(nodes[n-1], "rollup_"))
+	if !ok || err != nil {
+		return "", RollupSpec{}, false
+	}
+	return strings.Join(nodes[:n-2], "."), RollupSpec{fn, interval}, true
+}
+
+// parseRollupNodes is ParseRollup for tags, where sep separates keys and values and join separates nodes
+func parseRollupNodes(nodes []string, sep, join string) (raw string, spec RollupSpec, ok bool) {
+	si, ri := -1, -1
+	var stat, rollup string
+	for i, node := range nodes {
+		k, v, isTag := splitTag(node, sep)
+		switch {
+		case isTag && k == "stat":
+			si, stat = i, v
+		case isTag && k == "rollup":
+			ri, rollup = i, v
+		}
+	}
+	if si < 0 || ri < 0 {
+		return "", RollupSpec{}, false
+	}
+	stat, innerStat := splitNested(stat)
+	rollup, innerRollup := splitNested(rollup)
+	fn, ok := rollupStats[stat]
+	interval, err := ParseTimespec(rollup)
+	if !ok || err != nil {
+		return "", RollupSpec{}, false
+	}
+
+	var out []string
+	for i, node := range nodes {
+		switch {
+		case i == si && innerStat != "":
+			out = append(out, "stat"+sep+innerStat)
+		case i == ri && innerRollup != "":
+			out = append(out, "rollup"+sep+innerRollup)
+		case i != si && i != ri:
+			out = append(out, node)
+		}
+	}
+	return strings.Join(out, join), RollupSpec{fn, interval}, true
+}
+
+// splitNested splits a nested tag value such as max_of_mean into its outer and inner values
+func splitNested(v string) (outer, inner string) {
+	if i := strings.Index(v, statNest); i >= 0 {
+		return v[:i], v[i+len(statNest):]
+	}
+	return v, ""
+}
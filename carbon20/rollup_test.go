@@ -0,0 +1,93 @@
+package carbon20
+
+import (
+	"testing"
+
+	"github.com/bmizerany/assert"
+)
+
+func TestRollup(t *testing.T) {
+	hour := Timespec{1, "h"}
+	cases := []struct {
+		in  string
+		fn  string
+		out string
+	}{
+		{"what=lat.unit=ms.mtype=gauge", "max", "what=lat.unit=ms.mtype=gauge.stat=max.rollup=1h"},
+		{"what_is_lat.unit_is_ms", "avg", "what_is_lat.unit_is_ms.stat_is_mean.rollup_is_1h"},
+		{"what=lat.unit=ms.stat=mean.rollup=1m", "max", "what=lat.unit=ms.stat=max_of_mean.rollup=1h_of_1m"},
+		{"what=lat.unit=ms.stat=mean__1m", "last", "what=lat.unit=ms.stat=last_of_mean__1m.rollup=1h"},
+		{"foo.bar;dc=ams", "min", "foo.bar;dc=ams;stat=min;rollup=1h"},
+		{"foo.bar;stat=mean;rollup=1m", "sum", "foo.bar;stat=sum_of_mean;rollup=1h_of_1m"},
+		{"foo.bar", "max", "foo.bar.upper.rollup_1h"},
+		{"foo.bar", "avg", "foo.bar.mean.rollup_1h"},
+		{"foo.bar.mean.rollup_1m", "min", "foo.bar.mean.rollup_1m.lower.rollup_1h"},
+	}
+	for i, c := range cases {
+		out, err := Rollup(c.in, Prefixes{}, c.fn, hour)
+		if err != nil {
+			t.Fatalf("case %d: %s", i, err)
+		}
+		assert.Equal(t, c.out, out)
+
+		raw, spec, ok := ParseRollup(out)
+		if !ok {
+			t.Fatalf("case %d: ParseRollup(%q) failed", i, out)
+		}
+		assert.Equal(t, c.in, raw)
+		assert.Equal(t, RollupSpec{c.fn, hour}, spec)
+	}
+}
+
+func TestRollupErrors(t *testing.T) {
+	_, err := Rollup("foo.bar", Prefixes{}, "avg", Timespec{})
+	assert.Equal(t, errNoInterval, err)
+	_, err = Rollup("foo.bar", Prefixes{}, "p99", Timespec{1, "h"})
+	assert.Equal(t, `unknown consolidation function "p99"`, err.Error())
+	// rollups don't restrict the mtype
+	out, err := Rollup("what=lat.unit=ms.mtype=bogus", Prefixes{}, "avg", Timespec{1, "h"})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "what=lat.unit=ms.mtype=bogus.stat=mean.rollup=1h", out)
+	out, err = Rollup("foo.bar;mtype=bogus", Prefixes{}, "avg", Timespec{1, "h"})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "foo.bar;mtype=bogus;stat=mean;rollup=1h", out)
+}
+
+func TestRollupPrefixes(t *testing.T) {
+	p, err := NewPrefixes("p.", Tag{"our", "prefix"})
+	assert.Equal(t, nil, err)
+	hour := Timespec{1, "h"}
+	cases := []struct {
+		in  string
+		out string
+	}{
+		{"what=lat.unit=ms", "our=prefix.what=lat.unit=ms.stat=max.rollup=1h"},
+		{"what_is_lat.unit_is_ms", "our_is_prefix.what_is_lat.unit_is_ms.stat_is_max.rollup_is_1h"},
+		{"foo.bar;dc=ams", "p.foo.bar;dc=ams;stat=max;rollup=1h"},
+		{"foo.bar", "p.foo.bar.upper.rollup_1h"},
+	}
+	for _, c := range cases {
+		out, err := Rollup(c.in, p, "max", hour)
+		assert.Equal(t, nil, err)
+		assert.Equal(t, c.out, out)
+	}
+}
+
+func TestParseRollupRaw(t *testing.T) {
+	for _, in := range []string{
+		"foo.bar",
+		"foo.bar.upper",
+		"foo.bar.upper_90.rollup_1h",
+		"foo.rollup_1h",
+		"foo.bar.upper.rollup_soon",
+		"what=lat.unit=ms.stat=max",
+		"what=lat.unit=ms.stat=max_90.rollup=1h",
+		"what=lat.unit=ms.rollup=1h",
+		"foo.bar;stat=max",
+	} {
+		_, _, ok := ParseRollup(in)
+		if ok {
+			t.Fatalf("ParseRollup(%q): expected not a rollup", in)
+		}
+	}
+}
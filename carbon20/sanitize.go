@@ -0,0 +1,434 @@
+package carbon20
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+var errEmptyMetric = errors.New("metric is empty")
+var errTooLong = errors.New("metric can't be shortened enough")
+
+var errFmtUnrepairable = "can't repair %q: %s"
+
+// Sanitization is a set of repairs made by Sanitize
+type Sanitization int
+
+const (
+	SanitizeChars      Sanitization = 1 << iota // illegal characters were replaced with _
+	SanitizeEmptyNodes                          // empty nodes were removed
+	SanitizeStyle                               // _is_ tags in a metric with = tags were converted to =
+	SanitizeKeyCase                             // tag keys were lowercased
+	SanitizeTags                                // invalid graphite tags were repaired or removed
+	SanitizeUnit                                // the unit was replaced or added
+	SanitizeMType                               // the mtype was replaced or added
+	SanitizeLength                              // the metric was shortened, with a hash suffix
+)
+
+var sanitizationNames = []struct {
+	s    Sanitization
+	name string
+}{
+	{SanitizeChars, "chars"},
+	{SanitizeEmptyNodes, "empty_nodes"},
+	{SanitizeStyle, "style"},
+	{SanitizeKeyCase, "key_case"},
+	{SanitizeTags, "tags"},
+	{SanitizeUnit, "unit"},
+	{SanitizeMType, "mtype"},
+	{SanitizeLength, "length"},
+}
+
+// String returns the names of the repairs in the set, comma separated
+func (s Sanitization) String() string {
+	var names []string
+	for _, sn := range sanitizationNames {
+		if s&sn.s != 0 {
+			names = append(names, sn.name)
+		}
+	}
+	return strings.Join(names, ",")
+}
+
+// SanitizePolicy configures Sanitize
+type SanitizePolicy struct {
+	LevelLegacy ValidationLevelLegacy // level legacy metrics must pass
+	LevelM20    ValidationLevelM20    // level metrics 2.0 metrics must pass
+
+	// MaxLength is the maximum length of the output. 0 means no limit.
+	// longer metrics are shortened, keeping a hash of the full metric as suffix.
+	MaxLength int
+
+	// LowercaseKeys lowercases the keys of metrics 2.0 tags
+	LowercaseKeys bool
+
+	// Vocabulary repairs units and mtypes that are not in the vocabulary, so that the output
+	// also passes ValidateVocabularyM20
+	Vocabulary bool
+
+	// unit and mtype for metrics 2.0 metrics that have none, or one that is not in the vocabulary.
+	// without them, such metrics can't be repaired.
+	DefaultUnit  string
+	DefaultMType string
+}
+
+// hashSuffixLen is the length of the suffix that shortened metrics get: _ and 8 hex chars
+const hashSuffixLen = 9
+
+// Sanitize repairs common mistakes in a metric, so that it passes validation at the level chosen in policy.
+// Illegal characters are replaced with _, empty nodes are removed, and metrics 2.0 metrics that mix = and _is_
+// are converted to =. Metrics 2.0 metrics that lack a (valid) unit or mtype get the ones from the policy,
+// though units with a common alternative spelling, such as bytes, get the standard one instead.
+// Invalid graphite tags are repaired, or removed if they are empty, and metrics longer than policy.MaxLength
+// are shortened and get a hash suffix.
+// Only the repairs needed for the level are made, except that the metrics 2.0 levels other than NoneM20
+// also get the empty node repairs and the character repairs of StrictLegacy, or with UTF8M20, the character
+// repairs of UTF8Legacy.
+// If err is nil, the output passes validation at the chosen level, as done by a PacketParser with the same
+// levels and vocabulary option, which detects the version with GetVersionB. Metrics that can't be repaired,
+// such as metrics 2.0 metrics without a unit when the policy has no default, return an error.
+func Sanitize(in string, policy SanitizePolicy) (out string, changes Sanitization, err error) {
+	if in == "" {
+		return "", 0, errEmptyMetric
+	}
+	if strings.IndexByte(in, ';') < 0 && GetVersion(in) != Legacy {
+		out, changes, err = sanitizeM20(in, policy)
+	} else {
+		out, changes, err = sanitizeLegacy(in, policy)
+	}
+	if err == nil {
+		// the repairs go by GetVersion, which may disagree with what the parser will make of the output
+		key := []byte(out)
+		err = validateKeyB(key, GetVersionB(key), policy.LevelLegacy, policy.LevelM20, policy.Vocabulary)
+	}
+	if err != nil {
+		return "", 0, fmt.Errorf(errFmtUnrepairable, in, err)
+	}
+	return out, changes, nil
+}
+
+// sensibleRune, asciiRune and utf8Rune decide which characters validateSensibleChars,
+// validateNotNullAsciiChars and validateUTF8Chars accept
+func sensibleRune(r rune) bool {
+	return (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '_' || r == '-' || r == '.'
+}
+
+func asciiRune(r rune) bool {
+	return r != 0 && r < utf8.RuneSelf
+}
+
+func utf8Rune(r rune) bool {
+	return r != utf8.RuneError && !unicode.IsControl(r)
+}
+
+// sanitizeChars replaces the characters of s that legal rejects, and invalid UTF-8, with _
+func sanitizeChars(s string, legal func(rune) bool, changes *Sanitization) string {
+	clean := true
+	for _, r := range s {
+		if !legal(r) {
+			clean = false
+			break
+		}
+	}
+	if clean {
+		return s
+	}
+	*changes |= SanitizeChars
+	return strings.Map(func(r rune) rune {
+		if legal(r) {
+			return r
+		}
+		return '_'
+	}, s)
+}
+
+// removeEmptyNodes removes empty nodes from the dot separated s
+func removeEmptyNodes(s string, changes *Sanitization) string {
+	if !strings.HasPrefix(s, ".") && !strings.HasSuffix(s, ".") && !strings.Contains(s, "..") {
+		return s
+	}
+	*changes |= SanitizeEmptyNodes
+	nodes := strings.Split(s, ".")
+	out := nodes[:0]
+	for _, node := range nodes {
+		if node != "" {
+			out = append(out, node)
+		}
+	}
+	return strings.Join(out, ".")
+}
+
+func sanitizeLegacy(in string, policy SanitizePolicy) (string, Sanitization, error) {
+	var changes Sanitization
+	key, appendix := in, ""
+	if i := strings.IndexByte(in, ';'); i >= 0 {
+		key, appendix = in[:i], in[i+1:]
+	}
+
+	if policy.LevelLegacy != NoneLegacy {
+		legal, appendixLegal := asciiRune, asciiRune
+		switch policy.LevelLegacy {
+		case StrictLegacy:
+			key = removeEmptyNodes(key, &changes)
+			legal = sensibleRune
+		case UTF8Legacy:
+			legal, appendixLegal = utf8Rune, utf8Rune
+		}
+		key = sanitizeChars(key, legal, &changes)
+		if key == "" {
+			return "", 0, errEmptyKey
+		}
+		if appendix != "" || strings.IndexByte(in, ';') >= 0 {
+			appendix = sanitizeAppendix(appendix, appendixLegal, &changes)
+		}
+	}
+
+	suffix := ""
+	if appendix != "" {
+		suffix = ";" + appendix
+	}
+	if policy.MaxLength > 0 && len(key)+len(suffix) > policy.MaxLength {
+		max := policy.MaxLength - len(suffix) - hashSuffixLen
+		if max < 1 {
+			return "", 0, errTooLong
+		}
+		key = strings.TrimRight(truncate(key, max), ".") + "_" + shortHash(in)
+		changes |= SanitizeLength
+	}
+	return key + suffix, changes, nil
+}
+
+// sanitizeAppendix repairs the graphite tags in appendix, which excludes the leading ;
+// so that ValidateTagAppendixB accepts them and they only have characters that legal accepts
+func sanitizeAppendix(appendix string, legal func(rune) bool, changes *Sanitization) string {
+	var tags []string
+	for _, tag := range strings.Split(appendix, ";") {
+		k, v, ok := splitTag(tag, "=")
+		if !ok || v == "" {
+			*changes |= SanitizeTags
+			continue
+		}
+		if strings.IndexByte(k, '!') >= 0 || strings.IndexByte(v, '=') >= 0 {
+			*changes |= SanitizeTags
+			k = strings.Replace(k, "!", "_", -1)
+			v = strings.Replace(v, "=", "_", -1)
+		}
+		var charChanges Sanitization
+		k = sanitizeChars(k, legal, &charChanges)
+		v = sanitizeChars(v, legal, &charChanges)
+		if charChanges != 0 {
+			*changes |= SanitizeTags
+		}
+		tags = append(tags, k+"="+v)
+	}
+	return strings.Join(tags, ";")
+}
+
+func sanitizeM20(in string, policy SanitizePolicy) (string, Sanitization, error) {
+	var changes Sanitization
+	ver := GetVersion(in)
+	sep := tagSep(ver)
+	check := policy.LevelM20 != NoneM20
+	vocab := policy.Vocabulary
+	legal := sensibleRune
+	if policy.LevelM20 == UTF8M20 {
+		legal = utf8Rune
+	}
+
+	key := in
+	if check {
+		key = removeEmptyNodes(key, &changes)
+	}
+	nodes := strings.Split(key, ".")
+	var units, mtypes []int
+	for i, node := range nodes {
+		k, v, ok := splitTag(node, sep)
+		if !ok && ver == M20 && check {
+			// a tag in the other style
+			if k, v, ok = splitTag(node, "_is_"); ok {
+				changes |= SanitizeStyle
+			}
+		}
+		if !ok {
+			if check {
+				nodes[i] = sanitizeM20Chars(node, ver, legal, &changes)
+			}
+			continue
+		}
+		if policy.LowercaseKeys && k != strings.ToLower(k) {
+			changes |= SanitizeKeyCase
+			k = strings.ToLower(k)
+		}
+		if check {
+			k = sanitizeM20Chars(k, ver, legal, &changes)
+		}
+		switch {
+		case k == AggByTagKey && check:
+			// keep the separator between the keys, see AggregatedBy
+			v = sanitizeM20Chars(v, ver, func(r rune) bool { return strings.ContainsRune(aggBySep, r) || legal(r) }, &changes)
+		case k == "unit" && ValidateUnit(v) == nil:
+			// valid units may have characters that are otherwise illegal, such as %
+		case k == "unit" && vocab:
+			if sug, ok := SuggestUnit(v); ok && ValidateUnit(sug) == nil {
+				changes |= SanitizeUnit
+				v = sug
+				break
+			}
+			fallthrough
+		default:
+			if check {
+				v = sanitizeM20Chars(v, ver, legal, &changes)
+			}
+		}
+		switch k {
+		case "unit":
+			units = append(units, i)
+		case "mtype":
+			mtypes = append(mtypes, i)
+		}
+		nodes[i] = k + sep + v
+	}
+
+	if check || vocab {
+		var err error
+		nodes, err = sanitizeVocabulary(nodes, sep, units, mtypes, check, policy, &changes)
+		if err != nil {
+			return "", 0, err
+		}
+	}
+	if check {
+		if len(nodes) < 3 {
+			return "", 0, errNotEnoughTags
+		}
+	}
+
+	if policy.MaxLength > 0 {
+		var err error
+		nodes, err = shortenM20(nodes, sep, in, policy.MaxLength, &changes)
+		if err != nil {
+			return "", 0, err
+		}
+	}
+	return strings.Join(nodes, "."), changes, nil
+}
+
+// sanitizeM20Chars is sanitizeChars for a key, value or untagged node of a metrics 2.0 metric of version ver.
+// it also breaks up any _is_ in metrics with = tags, so they don't mix styles.
+func sanitizeM20Chars(s string, ver metricVersion, legal func(rune) bool, changes *Sanitization) string {
+	s = sanitizeChars(s, legal, changes)
+	if ver == M20 && strings.Contains(s, "_is_") {
+		*changes |= SanitizeStyle
+		s = strings.Replace(s, "_is_", "-is-", -1)
+	}
+	return s
+}
+
+// sanitizeVocabulary makes sure that all the unit and mtype tags of nodes, at the positions in units and mtypes,
+// are in the vocabulary if policy.Vocabulary is set. if required, missing tags are added.
+func sanitizeVocabulary(nodes []string, sep string, units, mtypes []int, required bool, policy SanitizePolicy, changes *Sanitization) ([]string, error) {
+	validUnit, validMT := validateVocabularyUnit, validMType
+	if !policy.Vocabulary {
+		validUnit, validMT = anyValue, anyValue
+	}
+	nodes, fixed, err := sanitizeTag(nodes, sep, "unit", units, required, validUnit, policy.DefaultUnit, errNoUnit)
+	if err != nil {
+		return nil, err
+	}
+	if fixed {
+		*changes |= SanitizeUnit
+	}
+	nodes, fixed, err = sanitizeTag(nodes, sep, "mtype", mtypes, required, validMT, policy.DefaultMType, errNoMType)
+	if err != nil {
+		return nil, err
+	}
+	if fixed {
+		*changes |= SanitizeMType
+	}
+	return nodes, nil
+}
+
+// sanitizeTag replaces the values of the key tags at the positions in idx that valid rejects with def.
+// if required and there are no such tags, one with def is added. fixed reports whether nodes were changed.
+// it returns missing if def is needed, but empty or rejected by valid.
+func sanitizeTag(nodes []string, sep, key string, idx []int, required bool, valid func(string) error, def string, missing error) (out []string, fixed bool, err error) {
+	defOK := def != "" && valid(def) == nil
+	for _, i := range idx {
+		_, v, _ := splitTag(nodes[i], sep)
+		if valid(v) == nil {
+			continue
+		}
+		if !defOK {
+			return nil, false, missing
+		}
+		nodes[i] = key + sep + def
+		fixed = true
+	}
+	if len(idx) == 0 && required {
+		if !defOK {
+			return nil, false, missing
+		}
+		nodes = append(nodes, key+sep+def)
+		fixed = true
+	}
+	return nodes, fixed, nil
+}
+
+func anyValue(string) error {
+	return nil
+}
+
+func validMType(s string) error {
+	_, err := ParseMType(s)
+	return err
+}
+
+// shortenM20 shortens the longest tag value (or untagged node) that is not the unit or mtype,
+// so that the joined nodes fit in max, and adds a hash of in as a suffix to it
+func shortenM20(nodes []string, sep, in string, max int, changes *Sanitization) ([]string, error) {
+	n := len(nodes) - 1
+	longest, longestLen := -1, 0
+	for i, node := range nodes {
+		n += len(node)
+		k, v, ok := splitTag(node, sep)
+		if ok && (k == "unit" || k == "mtype") {
+			continue
+		}
+		if len(v) > longestLen {
+			longest, longestLen = i, len(v)
+		}
+	}
+	if n <= max {
+		return nodes, nil
+	}
+	over := n - max
+	if longest < 0 || longestLen-over-hashSuffixLen < 1 {
+		return nil, errTooLong
+	}
+	k, v, ok := splitTag(nodes[longest], sep)
+	v = truncate(v, len(v)-over-hashSuffixLen) + "_" + shortHash(in)
+	if ok {
+		nodes[longest] = k + sep + v
+	} else {
+		nodes[longest] = v
+	}
+	*changes |= SanitizeLength
+	return nodes, nil
+}
+
+// truncate returns s cut to at most n bytes, without splitting a UTF-8 sequence
+func truncate(s string, n int) string {
+	for n > 0 && n < len(s) && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
+
+// shortHash returns 8 hex chars identifying s
+func shortHash(s string) string {
+	h := fnv.New32a()
+	h.Write([]byte(s))
+	return fmt.Sprintf("%08x", h.Sum32())
+}
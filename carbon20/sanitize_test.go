@@ -0,0 +1,199 @@
+package carbon20
+
+import (
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/bmizerany/assert"
+)
+
+// validateSanitized validates a metric at the levels of policy, detecting its version like PacketParser does
+func validateSanitized(in string, policy SanitizePolicy) error {
+	key := []byte(in)
+	ver := GetVersionB(key)
+	if policy.Vocabulary && ver != Legacy {
+		if err := ValidateVocabularyM20B(key); err != nil {
+			return err
+		}
+	}
+	switch ver {
+	case M20:
+		return ValidateKeyM20B(key, policy.LevelM20)
+	case M20NoEquals:
+		return ValidateKeyM20NoEqualsB(key, policy.LevelM20)
+	}
+	return ValidateKeyLegacyB(key, policy.LevelLegacy)
+}
+
+func TestSanitize(t *testing.T) {
+	strict := SanitizePolicy{}
+	medium := SanitizePolicy{LevelLegacy: MediumLegacy, LevelM20: MediumM20}
+	vocab := SanitizePolicy{Vocabulary: true}
+	defaults := SanitizePolicy{Vocabulary: true, DefaultUnit: "B", DefaultMType: "gauge"}
+	lower := SanitizePolicy{LevelM20: MediumM20, LowercaseKeys: true}
+	long := "foo.bar.baz.quux.something"
+	longM20 := "what=averyveryverylongname.unit=B.mtype=gauge"
+	cases := []struct {
+		policy  SanitizePolicy
+		in      string
+		out     string
+		changes Sanitization
+	}{
+		{strict, "foo.bar", "foo.bar", 0},
+		{strict, ".foo:bar..baz.", "foo_bar.baz", SanitizeChars | SanitizeEmptyNodes},
+		{medium, "foo:bar..baz", "foo:bar..baz", 0},
+		{medium, "föo.bar\x00", "f_o.bar_", SanitizeChars},
+		{strict, "foo.bar;dc=ams;=x;host=a=b;k!=v;n=", "foo.bar;dc=ams;host=a_b;k_=v", SanitizeTags},
+		{strict, "foo.bar;=x", "foo.bar", SanitizeTags},
+		{vocab, "what=rx..unit=bytes.mtype=counter", "what=rx.unit=B.mtype=counter", SanitizeEmptyNodes | SanitizeUnit},
+		{strict, "what=rx.unit=bytes.mtype=counter", "what=rx.unit=bytes.mtype=counter", 0},
+		{vocab, "what=rx.unit=Unknown.mtype=gauge", "what=rx.unit=Unknown.mtype=gauge", 0},
+		{medium, "what=rx.unit=bytes.mtype=counter", "what=rx.unit=bytes.mtype=counter", 0},
+		{medium, "what=rx.unit_is_B.mtype=gauge", "what=rx.unit=B.mtype=gauge", SanitizeStyle},
+		{medium, "what=this_is_it.unit=B.mtype=gauge", "what=this-is-it.unit=B.mtype=gauge", SanitizeStyle},
+		{lower, "What=rx.Unit=B.mtype=gauge", "what=rx.unit=B.mtype=gauge", SanitizeKeyCase},
+		{defaults, "what=rx.host=a", "what=rx.host=a.unit=B.mtype=gauge", SanitizeUnit | SanitizeMType},
+		{defaults, "what=rx.unit=foo.mtype=foo", "what=rx.unit=B.mtype=gauge", SanitizeUnit | SanitizeMType},
+		{defaults, "what=rx.unit=Bfoo.unit=B.mtype=gauge", "what=rx.unit=B.unit=B.mtype=gauge", SanitizeUnit},
+		{defaults, "what=rx.mtype=x.mtype=gauge.unit=B", "what=rx.mtype=gauge.mtype=gauge.unit=B", SanitizeMType},
+		{strict, "what=rx:tx.unit=%.mtype=gauge", "what=rx_tx.unit=%.mtype=gauge", SanitizeChars},
+		{strict, "what_is_rx:tx.unit_is_B.mtype_is_gauge", "what_is_rx_tx.unit_is_B.mtype_is_gauge", SanitizeChars},
+		{SanitizePolicy{MaxLength: 20}, long, "foo.bar.baz_" + shortHash(long), SanitizeLength},
+		{SanitizePolicy{MaxLength: 20}, "foo.bar", "foo.bar", 0},
+		{SanitizePolicy{MaxLength: 40}, longM20, "what=averyve_" + shortHash(longM20) + ".unit=B.mtype=gauge", SanitizeLength},
+		{SanitizePolicy{LevelM20: NoneM20}, "what=rx:tx", "what=rx:tx", 0},
+		{strict, "what=rx.unit=B.mtype=gauge.agg_by=host+d:c", "what=rx.unit=B.mtype=gauge.agg_by=host+d_c", SanitizeChars},
+		{SanitizePolicy{LevelLegacy: UTF8Legacy}, "zürich.b\xbdz\t;city=zü\x00rich", "zürich.b_z_;city=zü_rich", SanitizeChars | SanitizeTags},
+		{SanitizePolicy{LevelM20: UTF8M20}, "what=zürich:\x01.unit=B.mtype=gauge", "what=zürich:_.unit=B.mtype=gauge", SanitizeChars},
+	}
+	for i, c := range cases {
+		out, changes, err := Sanitize(c.in, c.policy)
+		if err != nil {
+			t.Fatalf("case %d: Sanitize(%q): %s", i, c.in, err)
+		}
+		if out != c.out || changes != c.changes {
+			t.Fatalf("case %d: Sanitize(%q): expected %q (%s), got %q (%s)", i, c.in, c.out, c.changes, out, changes)
+		}
+		if c.policy.MaxLength > 0 && len(out) > c.policy.MaxLength {
+			t.Fatalf("case %d: Sanitize(%q): %q is longer than %d", i, c.in, out, c.policy.MaxLength)
+		}
+		if err := validateSanitized(out, c.policy); err != nil {
+			t.Fatalf("case %d: Sanitize(%q): %q doesn't validate: %s", i, c.in, out, err)
+		}
+		again, changes, err := Sanitize(out, c.policy)
+		if err != nil || again != out || changes != 0 {
+			t.Fatalf("case %d: Sanitize(%q) is not idempotent: got %q (%s), %v", i, out, again, changes, err)
+		}
+	}
+}
+
+func TestSanitizeErrors(t *testing.T) {
+	vocab := SanitizePolicy{Vocabulary: true}
+	cases := []struct {
+		policy SanitizePolicy
+		in     string
+		err    error
+	}{
+		{SanitizePolicy{}, "", errEmptyMetric},
+		{SanitizePolicy{}, "..;a=b", errEmptyKey},
+		{SanitizePolicy{}, "what=rx.host=a", errNoUnit},
+		{SanitizePolicy{DefaultUnit: "B"}, "what=rx.host=a", errNoMType},
+		{SanitizePolicy{Vocabulary: true, DefaultUnit: "foo", DefaultMType: "gauge"}, "what=rx.host=a", errNoUnit},
+		{SanitizePolicy{}, "unit=B.mtype=gauge", errNotEnoughTags},
+		{SanitizePolicy{MaxLength: 5}, "foo.bar", errTooLong},
+		{SanitizePolicy{MaxLength: 20}, "what=rx.unit=B.mtype=gauge", errTooLong},
+		{vocab, "what=rx.unit=Bfoo.unit=B.mtype=gauge", errNoUnit},
+		{vocab, "what=rx.mtype=x.mtype=gauge.unit=B", errNoMType},
+		// the parser takes the output, a_b;c=d, for metrics 2.0
+		{SanitizePolicy{}, "a=b;c=d", errNoUnit},
+	}
+	for i, c := range cases {
+		out, _, err := Sanitize(c.in, c.policy)
+		if err == nil || !strings.HasSuffix(err.Error(), c.err.Error()) {
+			t.Fatalf("case %d: Sanitize(%q): expected error %q, got %q, %v", i, c.in, c.err, out, err)
+		}
+	}
+}
+
+// TestSanitizeValidates checks that whatever Sanitize returns passes validation
+func TestSanitizeValidates(t *testing.T) {
+	inputs := []string{
+		"foo.bar",
+		"..foo...bar..",
+		"foo bar.baz:qux/quux",
+		"föö.bär;tag=välue",
+		"foo;;a=b;c;d=e=f",
+		"what=a=b.unit=B.mtype=gauge",
+		"what_is_rx.unit=B.mtype=gauge.mixed_is_style",
+		"what=rx.unit=requests/s.mtype=rate",
+		"what=rx.unit=B.mtype=counter.extra",
+		".what=rx..unit=%.mtype=timestamp.",
+		"what=rx\x00.unit=B.mtype=gauge",
+		"zü\xbdrich.stra\x7fße;tag=\x01välue",
+		"what=zürich\u0085.unit=B.mtype=gauge",
+		"what=x.unit=Bfoo.unit=B.mtype=gauge",
+		"what=x.mtype=x.mtype=gauge.unit=B",
+		"a=b;c=d",
+		"foo.what=x.unit=B.mtype=gauge",
+	}
+	for _, policy := range sanitizePolicies() {
+		for _, in := range inputs {
+			checkSanitized(t, in, policy)
+		}
+	}
+	assert.Equal(t, "chars,length", (SanitizeChars | SanitizeLength).String())
+}
+
+// TestSanitizeProperty checks that Sanitize returns valid metrics or an error, for random metrics
+// made of pieces that are known to trip it up
+func TestSanitizeProperty(t *testing.T) {
+	pieces := []string{"what", "unit", "mtype", "agg_by", "B", "Bfoo", "gauge", "x", "=", "_is_", "_", ".", "..", ";", "+", ":", "!", "ü", "\xbd", "\x00", " "}
+	policies := sanitizePolicies()
+	r := rand.New(rand.NewSource(1))
+	n, repaired := 20000, 0
+	for i := 0; i < n; i++ {
+		var in []byte
+		for j := 1 + r.Intn(12); j > 0; j-- {
+			in = append(in, pieces[r.Intn(len(pieces))]...)
+		}
+		if checkSanitized(t, string(in), policies[r.Intn(len(policies))]) {
+			repaired++
+		}
+	}
+	// make sure the property is not checked only on errors
+	if repaired < n/4 {
+		t.Fatalf("only %d of %d metrics could be sanitized", repaired, n)
+	}
+}
+
+// sanitizePolicies returns policies with all combinations of levels, with and without MaxLength and Vocabulary
+func sanitizePolicies() []SanitizePolicy {
+	var policies []SanitizePolicy
+	for _, ll := range []ValidationLevelLegacy{StrictLegacy, MediumLegacy, NoneLegacy, UTF8Legacy} {
+		for _, lm := range []ValidationLevelM20{StrictM20, MediumM20, NoneM20, UTF8M20} {
+			for _, max := range []int{0, 30} {
+				for _, vocab := range []bool{false, true} {
+					policies = append(policies, SanitizePolicy{LevelLegacy: ll, LevelM20: lm, MaxLength: max, Vocabulary: vocab, DefaultUnit: "B", DefaultMType: "gauge"})
+				}
+			}
+		}
+	}
+	return policies
+}
+
+// checkSanitized checks that if Sanitize succeeds, its output validates and is not too long.
+// it returns whether Sanitize succeeded.
+func checkSanitized(t *testing.T, in string, policy SanitizePolicy) bool {
+	out, _, err := Sanitize(in, policy)
+	if err != nil {
+		return false
+	}
+	if err := validateSanitized(out, policy); err != nil {
+		t.Fatalf("Sanitize(%q, %+v) = %q, which doesn't validate: %s", in, policy, out, err)
+	}
+	if policy.MaxLength > 0 && len(out) > policy.MaxLength {
+		t.Fatalf("Sanitize(%q, %+v) = %q, which is too long", in, policy, out)
+	}
+	return true
+}
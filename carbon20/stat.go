@@ -0,0 +1,124 @@
+package carbon20
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var errStatConflict = errors.New("metric already has a stat tag")
+var errEmptyTimespec = errors.New("timespec must be non-empty")
+
+var errFmtInvalidTimespec = "invalid timespec %q: want a positive number followed by one of s, m, h, d, w, y"
+var errFmtInvalidPercentile = "invalid percentile %q: want digits only"
+
+// StatPolicy decides what happens when a statistic is applied to a metric that already has a stat tag,
+// e.g. taking the max of a metric with stat=mean.
+type StatPolicy int
+
+const (
+	StatNest    StatPolicy = iota // combine the stats, outer first: stat=max_of_mean
+	StatReplace                   // replace the existing stat: stat=max
+	StatError                     // refuse to apply the statistic
+)
+
+// statNest joins an outer and inner stat value when nesting them
+const statNest = "_of_"
+
+// timespecUnits maps the units a timespec can be expressed in to their duration
+var timespecUnits = map[string]time.Duration{
+	"s": time.Second,
+	"m": time.Minute,
+	"h": time.Hour,
+	"d": 24 * time.Hour,
+	"w": 7 * 24 * time.Hour,
+	"y": 365 * 24 * time.Hour,
+}
+
+// Timespec is the time window a statistic is computed over, such as 1m or 1h.
+// The zero value means no timespec.
+type Timespec struct {
+	N    int
+	Unit string // one of s, m, h, d, w, y
+}
+
+// ParseTimespec parses a timespec such as 1m or 1h
+func ParseTimespec(s string) (Timespec, error) {
+	if s == "" {
+		return Timespec{}, errEmptyTimespec
+	}
+	i := strings.IndexFunc(s, func(r rune) bool { return r < '0' || r > '9' })
+	if i <= 0 {
+		return Timespec{}, fmt.Errorf(errFmtInvalidTimespec, s)
+	}
+	n, err := strconv.Atoi(s[:i])
+	if err != nil || n == 0 {
+		return Timespec{}, fmt.Errorf(errFmtInvalidTimespec, s)
+	}
+	if _, ok := timespecUnits[s[i:]]; !ok {
+		return Timespec{}, fmt.Errorf(errFmtInvalidTimespec, s)
+	}
+	return Timespec{n, s[i:]}, nil
+}
+
+// IsZero returns whether t is the zero value, i.e. no timespec
+func (t Timespec) IsZero() bool {
+	return t == Timespec{}
+}
+
+// String returns the timespec as used in metric names, e.g. 1m. the zero value returns an empty string
+func (t Timespec) String() string {
+	if t.IsZero() {
+		return ""
+	}
+	return strconv.Itoa(t.N) + t.Unit
+}
+
+// Duration returns the length of the time window. years are 365 days.
+func (t Timespec) Duration() time.Duration {
+	return time.Duration(t.N) * timespecUnits[t.Unit]
+}
+
+// validate returns an error if t is not the zero value, nor a timespec ParseTimespec would return
+func (t Timespec) validate() error {
+	if t.IsZero() {
+		return nil
+	}
+	if _, ok := timespecUnits[t.Unit]; !ok || t.N <= 0 {
+		return fmt.Errorf(errFmtInvalidTimespec, t.String())
+	}
+	return nil
+}
+
+// validateStatParams returns an error if the percentile has anything but digits,
+// as it would end up in the stat tag as is, or if the timespec is invalid
+func validateStatParams(percentile string, timespec Timespec) error {
+	for i := 0; i < len(percentile); i++ {
+		if percentile[i] < '0' || percentile[i] > '9' {
+			return fmt.Errorf(errFmtInvalidPercentile, percentile)
+		}
+	}
+	return timespec.validate()
+}
+
+// statValue returns the value of a stat tag, or legacy stat node, for the given stat.
+// percentile and timespec are optional and get added with underscores, e.g. max_90__1m
+func statValue(stat, percentile string, timespec Timespec) string {
+	if percentile != "" {
+		stat += "_" + percentile
+	}
+	if !timespec.IsZero() {
+		stat += "__" + timespec.String()
+	}
+	return stat
+}
+
+// stackStat returns the stat value to use when applying stat to a metric that has stat tag value existing
+func stackStat(stat, existing string, policy StatPolicy) string {
+	if policy == StatNest {
+		return stat + statNest + existing
+	}
+	return stat
+}
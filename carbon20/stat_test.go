@@ -0,0 +1,81 @@
+package carbon20
+
+import (
+	"testing"
+	"time"
+
+	"github.com/bmizerany/assert"
+)
+
+func TestParseTimespec(t *testing.T) {
+	cases := []struct {
+		in  string
+		ts  Timespec
+		dur time.Duration
+		ok  bool
+	}{
+		{"1m", Timespec{1, "m"}, time.Minute, true},
+		{"10s", Timespec{10, "s"}, 10 * time.Second, true},
+		{"2w", Timespec{2, "w"}, 14 * 24 * time.Hour, true},
+		{"", Timespec{}, 0, false},
+		{"m", Timespec{}, 0, false},
+		{"0h", Timespec{}, 0, false},
+		{"1min", Timespec{}, 0, false},
+		{"-1h", Timespec{}, 0, false},
+		{"1.5h", Timespec{}, 0, false},
+	}
+	for i, c := range cases {
+		ts, err := ParseTimespec(c.in)
+		if (err == nil) != c.ok {
+			t.Fatalf("case %d: ParseTimespec(%q): unexpected error %v", i, c.in, err)
+		}
+		assert.Equal(t, c.ts, ts)
+		assert.Equal(t, c.dur, ts.Duration())
+		if c.ok {
+			assert.Equal(t, c.in, ts.String())
+		}
+	}
+	assert.Equal(t, "", Timespec{}.String())
+}
+
+// a 1h max of a 1m mean, as done by multi-level rollups
+func TestStackedStats(t *testing.T) {
+	hourly := func(in string, policy StatPolicy) (string, error) {
+		return StatTransform("upper", "max", "", Timespec{1, "h"}, policy).Apply(in, Prefixes{})
+	}
+	cases := []struct {
+		in     string
+		policy StatPolicy
+		out    string
+		err    error
+	}{
+		{"what=lat.unit=ms.stat=mean__1m", StatNest, "what=lat.unit=ms.stat=max__1h_of_mean__1m", nil},
+		{"what_is_lat.stat_is_mean.unit_is_ms", StatNest, "what_is_lat.stat_is_max__1h_of_mean.unit_is_ms", nil},
+		{"what=lat.unit=ms.stat=mean__1m", StatReplace, "what=lat.unit=ms.stat=max__1h", nil},
+		{"what=lat.unit=ms.stat=mean__1m", StatError, "", errStatConflict},
+		{"what=lat.unit=ms", StatError, "what=lat.unit=ms.stat=max__1h", nil},
+		{"foo.bar.mean__1m", StatError, "foo.bar.mean__1m.upper__1h", nil},
+	}
+	for i, c := range cases {
+		out, err := hourly(c.in, c.policy)
+		if err != c.err {
+			t.Fatalf("case %d: expected error %v, got %v", i, c.err, err)
+		}
+		assert.Equal(t, c.out, out)
+	}
+	// the old functions nest, so they never produce two stat tags
+	assert.Equal(t, "what=lat.unit=ms.stat=max_of_mean", must(Max(must(Mean("what=lat.unit=ms", Prefixes{}, "", Timespec{})), Prefixes{}, "", Timespec{})))
+}
+
+func TestStatParams(t *testing.T) {
+	out, err := Max("what=lat.unit=ms", Prefixes{}, "99", Timespec{15, "s"})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "what=lat.unit=ms.stat=max_99__15s", out)
+	_, err = Max("what=lat.unit=ms", Prefixes{}, "9.9", Timespec{})
+	assert.Equal(t, `invalid percentile "9.9": want digits only`, err.Error())
+	_, err = Max("what=lat.unit=ms", Prefixes{}, "", Timespec{15, "x"})
+	assert.Equal(t, `invalid timespec "15x": want a positive number followed by one of s, m, h, d, w, y`, err.Error())
+	dst, err := AppendMean([]byte("junk"), []byte("what=lat.unit=ms"), Prefixes{}, "", Timespec{0, "m"})
+	assert.Equal(t, `invalid timespec "0m": want a positive number followed by one of s, m, h, d, w, y`, err.Error())
+	assert.Equal(t, "junk", string(dst))
+}
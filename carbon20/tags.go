@@ -0,0 +1,110 @@
+package carbon20
+
+import (
+	"bytes"
+	"strings"
+)
+
+// Tag is a key/value pair from a metrics 2.0 metric id
+type Tag struct {
+	Key   string
+	Value string
+}
+
+// tagSep returns the string that separates tag keys from values for the given version
+func tagSep(ver metricVersion) string {
+	if ver == M20NoEquals {
+		return "_is_"
+	}
+	return "="
+}
+
+// splitTag splits a node into key and value using sep.
+// ok is false if the node is not a tag (e.g. a plain "foo" node)
+func splitTag(node, sep string) (key, val string, ok bool) {
+	i := strings.Index(node, sep)
+	if i <= 0 {
+		return "", node, false
+	}
+	return node[:i], node[i+len(sep):], true
+}
+
+// ParseTags returns the tags of a metrics 2.0 metric id, in order of appearance.
+// nodes that are not tags are returned with an empty Key.
+// legacy metrics have no tags, so nil is returned for them.
+func ParseTags(in string) []Tag {
+	ver := GetVersion(in)
+	if ver == Legacy {
+		return nil
+	}
+	sep := tagSep(ver)
+	nodes := strings.Split(in, ".")
+	tags := make([]Tag, len(nodes))
+	for i, node := range nodes {
+		k, v, _ := splitTag(node, sep)
+		tags[i] = Tag{k, v}
+	}
+	return tags
+}
+
+// GetTag returns the value of the first tag with the given key, if any
+func GetTag(in, key string) (string, bool) {
+	ver := GetVersion(in)
+	if ver == Legacy {
+		return "", false
+	}
+	sep := tagSep(ver)
+	for _, node := range strings.Split(in, ".") {
+		k, v, ok := splitTag(node, sep)
+		if ok && k == key {
+			return v, true
+		}
+	}
+	return "", false
+}
+
+// replaceTag replaces the value of all tags with the given key.
+// ok is false if the metric has no such tag, in which case it is returned unchanged.
+func replaceTag(in, key, val string) (out string, ok bool) {
+	ver := GetVersion(in)
+	if ver == Legacy {
+		return in, false
+	}
+	sep := tagSep(ver)
+	nodes := strings.Split(in, ".")
+	for i, node := range nodes {
+		k, _, isTag := splitTag(node, sep)
+		if isTag && k == key {
+			nodes[i] = key + sep + val
+			ok = true
+		}
+	}
+	if !ok {
+		return in, false
+	}
+	return strings.Join(nodes, "."), true
+}
+
+// tagValueB returns the value of the first node starting with pre (e.g. "unit=") in metric_id
+func tagValueB(metric_id, pre []byte) []byte {
+	for len(metric_id) > 0 {
+		node := metric_id
+		if i := bytes.IndexByte(metric_id, '.'); i >= 0 {
+			node, metric_id = metric_id[:i], metric_id[i+1:]
+		} else {
+			metric_id = nil
+		}
+		if bytes.HasPrefix(node, pre) {
+			return node[len(pre):]
+		}
+	}
+	return nil
+}
+
+// cleanTagPart makes s usable as the key or value of a tag in the = style:
+// dots and = become underscores, and _is_ becomes -is- so tags don't mix styles.
+func cleanTagPart(s string) string {
+	s = strings.Replace(s, ".", "_", -1)
+	s = strings.Replace(s, "=", "_", -1)
+	return strings.Replace(s, "_is_", "-is-", -1)
+}
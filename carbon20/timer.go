@@ -0,0 +1,99 @@
+package carbon20
+
+import "strings"
+
+// TimerStat is a set of statistics that statsd derives from a timer
+type TimerStat int
+
+const (
+	TimerUpper            TimerStat = 1 << iota // max
+	TimerLower                                  // min
+	TimerMean                                   // mean
+	TimerSum                                    // sum
+	TimerStd                                    // standard deviation
+	TimerMedian                                 // median
+	TimerCount                                  // amount of packets received
+	TimerCountPs                                // rate of packets received
+	TimerUpperPercentiles                       // max of each of the configured percentiles
+
+	TimerAll = TimerUpper | TimerLower | TimerMean | TimerSum | TimerStd | TimerMedian | TimerCount | TimerCountPs | TimerUpperPercentiles
+)
+
+// TimerName is a metric derived from a timer
+type TimerName struct {
+	Stat       TimerStat // the single statistic this name represents
+	Percentile string    // only set for TimerUpperPercentiles
+	Name       string
+}
+
+// timerStats lists the simple statistics in the order statsd emits them, with their names for simpleStat
+var timerStats = []struct {
+	stat         TimerStat
+	legacy, name string
+}{
+	{TimerUpper, "upper", "max"},
+	{TimerLower, "lower", "min"},
+	{TimerMean, "mean", "mean"},
+	{TimerSum, "sum", "sum"},
+	{TimerStd, "std", "std"},
+	{TimerMedian, "median", "median"},
+}
+
+// TimerNames returns the names of all metrics derived from the given timer, for the statistics in stats.
+// This is equivalent to, but cheaper than, calling Max, Min, Mean, Sum, Std, Median, CountPckt, RatePckt
+// and Max for each percentile: the metric is only parsed once.
+// timespec is optional and applied to the simple statistics, like it is for Max etc.
+// stats are nested if the timer already has a stat tag, see StatNest.
+func TimerNames(in string, p Prefixes, percentiles []string, timespec Timespec, stats TimerStat) []TimerName {
+	ver := GetVersion(in)
+	prefix := p.For(ver)
+	sep := tagSep(ver)
+	hasStat := false
+	if ver != Legacy {
+		_, hasStat = GetTag(in, "stat")
+	}
+	statName := func(legacy, name, percentile string) string {
+		if ver == Legacy {
+			return prefix + in + "." + statValue(legacy, percentile, timespec)
+		}
+		if hasStat {
+			return StatTransform(legacy, name, percentile, timespec, StatNest).apply(in, p)
+		}
+		return prefix + in + ".stat" + sep + statValue(name, percentile, timespec)
+	}
+
+	var names []TimerName
+	for _, ts := range timerStats {
+		if stats&ts.stat != 0 {
+			names = append(names, TimerName{ts.stat, "", statName(ts.legacy, ts.name, "")})
+		}
+	}
+	if stats&(TimerCount|TimerCountPs) != 0 {
+		var nodes []string
+		if ver != Legacy {
+			nodes = strings.Split(in, ".")
+		}
+		for _, c := range []struct {
+			stat TimerStat
+			t    Transform
+		}{
+			{TimerCount, CountPcktTransform()},
+			{TimerCountPs, RatePcktTransform()},
+		} {
+			if stats&c.stat == 0 {
+				continue
+			}
+			name := prefix + in + c.t.LegacySuffix
+			if ver != Legacy {
+				name = prefix + c.t.applyNodes(append([]string(nil), nodes...), sep)
+			}
+			names = append(names, TimerName{c.stat, "", name})
+		}
+	}
+	if stats&TimerUpperPercentiles != 0 {
+		for _, pct := range percentiles {
+			names = append(names, TimerName{TimerUpperPercentiles, pct, statName("upper", "max", pct)})
+		}
+	}
+	return names
+}
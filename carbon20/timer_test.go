@@ -0,0 +1,46 @@
+package carbon20
+
+import (
+	"testing"
+
+	"github.com/bmizerany/assert"
+)
+
+func TestTimerNamesMatchesIndividualCalls(t *testing.T) {
+	p, err := NewPrefixes("stats.timers.", Tag{"src", "statsd"})
+	assert.Equal(t, nil, err)
+	for _, in := range []string{"foo.bar", "what=lat.unit=ms.mtype=gauge", "what_is_lat.unit_is_ms.mtype_is_gauge", "what=lat.unit=ms.stat=mean__1m"} {
+		for _, ts := range []Timespec{{}, {1, "m"}} {
+			exp := []TimerName{
+				{TimerUpper, "", must(Max(in, p, "", ts))},
+				{TimerLower, "", must(Min(in, p, "", ts))},
+				{TimerMean, "", must(Mean(in, p, "", ts))},
+				{TimerSum, "", must(Sum(in, p, "", ts))},
+				{TimerStd, "", must(Std(in, p, "", ts))},
+				{TimerMedian, "", must(Median(in, p, "", ts))},
+				{TimerCount, "", must(CountPckt(in, p))},
+				{TimerCountPs, "", must(RatePckt(in, p))},
+				{TimerUpperPercentiles, "90", must(Max(in, p, "90", ts))},
+				{TimerUpperPercentiles, "99", must(Max(in, p, "99", ts))},
+			}
+			assert.Equal(t, exp, TimerNames(in, p, []string{"90", "99"}, ts, TimerAll))
+		}
+	}
+}
+
+func TestTimerNamesSubset(t *testing.T) {
+	names := TimerNames("foo.bar", Prefixes{}, []string{"95"}, Timespec{}, TimerUpper|TimerCountPs)
+	exp := []TimerName{
+		{TimerUpper, "", "foo.bar.upper"},
+		{TimerCountPs, "", "foo.bar.count_ps"},
+	}
+	assert.Equal(t, exp, names)
+	assert.Equal(t, 0, len(TimerNames("foo.bar", Prefixes{}, nil, Timespec{}, TimerUpperPercentiles)))
+}
+
+func BenchmarkTimerNamesM20(b *testing.B) {
+	percentiles := []string{"90", "99"}
+	for i := 0; i < b.N; i++ {
+		TimerNames("what=lat.unit=ms.mtype=gauge", Prefixes{}, percentiles, Timespec{}, TimerAll)
+	}
+}
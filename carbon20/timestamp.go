@@ -0,0 +1,259 @@
+package carbon20
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+)
+
+var errTsOverflow = errors.New("timestamp out of range for int64 at the requested precision")
+
+var errFmtUnknownPrecision = "unknown precision %d"
+
+// errors returned for timestamps that violate a TimestampPolicy
+var (
+	ErrTsTooOld      = errors.New("timestamp too far in the past")
+	ErrTsTooNew      = errors.New("timestamp too far in the future")
+	ErrTsNotPositive = errors.New("timestamp must be positive")
+)
+
+// Precision is the unit of a timestamp
+type Precision int
+
+const (
+	PrecisionSecond Precision = iota
+	PrecisionMilli
+	PrecisionMicro
+	PrecisionNano
+)
+
+// precisionNanos is the length of the unit of each precision, in nanoseconds
+var precisionNanos = [...]int64{1e9, 1e6, 1e3, 1}
+
+// nanos returns the length of the precision's unit, in nanoseconds
+func (p Precision) nanos() (int64, error) {
+	if p < 0 || int(p) >= len(precisionNanos) {
+		return 0, fmt.Errorf(errFmtUnknownPrecision, int(p))
+	}
+	return precisionNanos[p], nil
+}
+
+// tsNanos converts ts, which has precision p, to nanoseconds
+func tsNanos(ts int64, p Precision) (int64, error) {
+	unit, err := p.nanos()
+	if err != nil {
+		return 0, err
+	}
+	if ts > math.MaxInt64/unit || ts < math.MinInt64/unit {
+		return 0, errTsOverflow
+	}
+	return ts * unit, nil
+}
+
+// String returns the symbol of the precision's unit: s, ms, us or ns
+func (p Precision) String() string {
+	switch p {
+	case PrecisionSecond:
+		return "s"
+	case PrecisionMilli:
+		return "ms"
+	case PrecisionMicro:
+		return "us"
+	case PrecisionNano:
+		return "ns"
+	}
+	return "Precision(" + strconv.Itoa(int(p)) + ")"
+}
+
+// millisThreshold is the smallest timestamp that DetectMillis treats as milliseconds.
+// in seconds, it would be in the year 5138.
+const millisThreshold = 1e11
+
+// TimestampOptions configures how timestamps are parsed
+type TimestampOptions struct {
+	Precision    Precision // precision of the returned timestamps. more precise input is truncated
+	DetectMillis bool      // treat timestamps of 1e11 and up as milliseconds rather than seconds
+	Policy       TimestampPolicy
+}
+
+// TimestampPolicy decides which timestamps are sane. The zero value accepts all timestamps.
+type TimestampPolicy struct {
+	MaxFuture         time.Duration    // how far timestamps may be ahead of the clock. 0 means no limit
+	MaxAge            time.Duration    // how far timestamps may be behind the clock. 0 means no limit
+	RejectNonPositive bool             // reject zero and negative timestamps
+	Clamp             bool             // clamp timestamps outside of the MaxAge and MaxFuture window to it, rather than rejecting them
+	Now               func() time.Time // the clock. nil means time.Now
+}
+
+// Check checks ts, which has precision p, against the policy, and returns the timestamp to use.
+// it returns ErrTsNotPositive, ErrTsTooOld or ErrTsTooNew for timestamps it rejects.
+func (tp TimestampPolicy) Check(ts int64, p Precision) (int64, error) {
+	if tp.RejectNonPositive && ts <= 0 {
+		return 0, ErrTsNotPositive
+	}
+	if tp.MaxAge == 0 && tp.MaxFuture == 0 {
+		return ts, nil
+	}
+	now := time.Now
+	if tp.Now != nil {
+		now = tp.Now
+	}
+	unit, err := p.nanos()
+	if err != nil {
+		return 0, err
+	}
+	t := now()
+	if tp.MaxAge != 0 {
+		min := t.Add(-tp.MaxAge).UnixNano() / unit
+		if ts < min {
+			if !tp.Clamp {
+				return 0, ErrTsTooOld
+			}
+			ts = min
+		}
+	}
+	if tp.MaxFuture != 0 {
+		max := t.Add(tp.MaxFuture).UnixNano() / unit
+		if ts > max {
+			if !tp.Clamp {
+				return 0, ErrTsTooNew
+			}
+			ts = max
+		}
+	}
+	return ts, nil
+}
+
+// ParseTimestamp parses the timestamp field of a carbon message, which is in seconds and may have a fraction,
+// and checks it against opts.Policy.
+// Unlike ValidatePacket, it returns an error if the timestamp doesn't fit in an int64 at the requested precision.
+func ParseTimestamp(buf []byte, opts TimestampOptions) (int64, error) {
+	ts, err := parseTimestamp(buf, opts)
+	if err != nil {
+		return 0, err
+	}
+	return opts.Policy.Check(ts, opts.Precision)
+}
+
+// parseTimestamp is ParseTimestamp without the policy check
+func parseTimestamp(buf []byte, opts TimestampOptions) (int64, error) {
+	if _, err := opts.Precision.nanos(); err != nil {
+		return 0, err
+	}
+	neg := len(buf) != 0 && buf[0] == '-'
+	digits := trimPlus(buf)
+	if neg {
+		digits = buf[1:]
+	}
+	intPart, frac, ok := splitDecimal(digits)
+	if !ok {
+		return parseTimestampFloat(buf, opts)
+	}
+
+	in := precisionNanos[PrecisionSecond]
+	out := precisionNanos[opts.Precision]
+	n, err := strconv.ParseInt(string(intPart), 10, 64)
+	if err != nil {
+		return 0, errTsOverflow
+	}
+	if opts.DetectMillis && n >= millisThreshold {
+		in = precisionNanos[PrecisionMilli]
+	}
+
+	if in < out {
+		n /= out / in
+	} else {
+		scale := in / out
+		if n > math.MaxInt64/scale {
+			return 0, errTsOverflow
+		}
+		n *= scale
+		// add as many digits of the fraction as the scale allows
+		for s := scale / 10; s > 0; s /= 10 {
+			if len(frac) != 0 {
+				n += int64(frac[0]-'0') * s
+				frac = frac[1:]
+			}
+		}
+		if n < 0 {
+			return 0, errTsOverflow
+		}
+	}
+	if neg {
+		n = -n
+	}
+	return n, nil
+}
+
+// splitDecimal splits digits with an optional decimal point into the integer part and the fraction.
+// ok is false if buf is something else, such as a number with an exponent.
+func splitDecimal(buf []byte) (intPart, frac []byte, ok bool) {
+	dot := -1
+	for i, c := range buf {
+		if c == '.' && dot < 0 {
+			dot = i
+			continue
+		}
+		if c < '0' || c > '9' {
+			return nil, nil, false
+		}
+	}
+	if dot < 0 {
+		return buf, nil, len(buf) != 0
+	}
+	if dot == 0 {
+		return []byte("0"), buf[1:], len(buf) > 1
+	}
+	return buf[:dot], buf[dot+1:], true
+}
+
+// parseTimestampFloat is ParseTimestamp for timestamps that are not plain decimals, such as 1.5e9
+func parseTimestampFloat(buf []byte, opts TimestampOptions) (int64, error) {
+	f, err := strconv.ParseFloat(string(buf), 64)
+	if err, ok := err.(*strconv.NumError); ok && err.Err == strconv.ErrRange {
+		return 0, errTsOverflow
+	}
+	if err != nil || math.IsNaN(f) {
+		return 0, errTsNotTs
+	}
+	if opts.DetectMillis && math.Abs(f) >= millisThreshold {
+		f /= 1e3
+	}
+	f *= float64(precisionNanos[PrecisionSecond] / precisionNanos[opts.Precision])
+	if f >= math.MaxInt64 || f < math.MinInt64 {
+		return 0, errTsOverflow
+	}
+	return int64(f), nil
+}
+
+// AppendTimestamp appends ts, which has the given precision, to dst as seconds, with a fraction if needed.
+// on error, dst is returned unchanged.
+func AppendTimestamp(dst []byte, ts int64, p Precision) ([]byte, error) {
+	unit, err := p.nanos()
+	if err != nil {
+		return dst, err
+	}
+	unit = precisionNanos[PrecisionSecond] / unit
+	if unit == 1 {
+		return strconv.AppendInt(dst, ts, 10), nil
+	}
+	sec, frac := ts/unit, ts%unit
+	if frac < 0 {
+		frac = -frac
+		if sec == 0 {
+			dst = append(dst, '-')
+		}
+	}
+	dst = strconv.AppendInt(dst, sec, 10)
+	if frac == 0 {
+		return dst, nil
+	}
+	dst = append(dst, '.')
+	for s := unit / 10; s > 0 && frac > 0; s /= 10 {
+		dst = append(dst, byte('0'+frac/s))
+		frac %= s
+	}
+	return dst, nil
+}
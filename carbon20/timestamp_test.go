@@ -0,0 +1,125 @@
+package carbon20
+
+import (
+	"testing"
+	"time"
+
+	"github.com/bmizerany/assert"
+)
+
+func TestParseTimestamp(t *testing.T) {
+	cases := []struct {
+		in   string
+		opts TimestampOptions
+		ts   int64
+		err  error
+	}{
+		{"1234567890", TimestampOptions{}, 1234567890, nil},
+		{"1234567890.999", TimestampOptions{}, 1234567890, nil},
+		{"1234567890.5", TimestampOptions{Precision: PrecisionMilli}, 1234567890500, nil},
+		{"1234567890.123456789", TimestampOptions{Precision: PrecisionNano}, 1234567890123456789, nil},
+		{"1234567890.1234567891", TimestampOptions{Precision: PrecisionMicro}, 1234567890123456, nil},
+		{"+1234567890", TimestampOptions{}, 1234567890, nil},
+		{"-1", TimestampOptions{}, -1, nil},
+		{"-1.5", TimestampOptions{Precision: PrecisionMilli}, -1500, nil},
+		{"4294967296", TimestampOptions{}, 4294967296, nil},
+		{".5", TimestampOptions{Precision: PrecisionMilli}, 500, nil},
+		{"1.5e9", TimestampOptions{Precision: PrecisionMilli}, 1500000000000, nil},
+		{"1234567890123", TimestampOptions{}, 1234567890123, nil},
+		{"1234567890123", TimestampOptions{DetectMillis: true}, 1234567890, nil},
+		{"1234567890123.5", TimestampOptions{Precision: PrecisionMicro, DetectMillis: true}, 1234567890123500, nil},
+		{"1.234567890123e12", TimestampOptions{DetectMillis: true}, 1234567890, nil},
+		{"9223372036", TimestampOptions{Precision: PrecisionNano}, 9223372036000000000, nil},
+		{"9223372037", TimestampOptions{Precision: PrecisionNano}, 0, errTsOverflow},
+		{"9223372036.854775808", TimestampOptions{Precision: PrecisionNano}, 0, errTsOverflow},
+		{"99999999999999999999", TimestampOptions{}, 0, errTsOverflow},
+		{"1e30", TimestampOptions{}, 0, errTsOverflow},
+		{"1e400", TimestampOptions{}, 0, errTsOverflow},
+		{"-1e400", TimestampOptions{}, 0, errTsOverflow},
+		{"", TimestampOptions{}, 0, errTsNotTs},
+		{".", TimestampOptions{}, 0, errTsNotTs},
+		{"123abc", TimestampOptions{}, 0, errTsNotTs},
+		{"NaN", TimestampOptions{}, 0, errTsNotTs},
+	}
+	for i, c := range cases {
+		ts, err := ParseTimestamp([]byte(c.in), c.opts)
+		if err != c.err {
+			t.Fatalf("case %d: ParseTimestamp(%q): expected error %v, got %v", i, c.in, c.err, err)
+		}
+		assert.Equal(t, c.ts, ts)
+	}
+	_, err := ParseTimestamp([]byte("1"), TimestampOptions{Precision: 7})
+	assert.Equal(t, "unknown precision 7", err.Error())
+}
+
+func TestAppendTimestamp(t *testing.T) {
+	cases := []struct {
+		ts  int64
+		p   Precision
+		out string
+	}{
+		{1234567890, PrecisionSecond, "1234567890"},
+		{1234567890500, PrecisionMilli, "1234567890.5"},
+		{1234567890000, PrecisionMilli, "1234567890"},
+		{1234567890000000001, PrecisionNano, "1234567890.000000001"},
+		{-1500, PrecisionMilli, "-1.5"},
+		{-500, PrecisionMilli, "-0.5"},
+	}
+	for _, c := range cases {
+		buf, err := AppendTimestamp(nil, c.ts, c.p)
+		assert.Equal(t, nil, err)
+		out := string(buf)
+		assert.Equal(t, c.out, out)
+		ts, err := ParseTimestamp([]byte(out), TimestampOptions{Precision: c.p})
+		assert.Equal(t, nil, err)
+		assert.Equal(t, c.ts, ts)
+	}
+	assert.Equal(t, "ms", PrecisionMilli.String())
+	buf, err := AppendTimestamp([]byte("foo "), 1, Precision(-1))
+	assert.Equal(t, "unknown precision -1", err.Error())
+	assert.Equal(t, "foo ", string(buf))
+}
+
+func TestTimestampPolicy(t *testing.T) {
+	now := time.Unix(1500000000, 0)
+	clock := func() time.Time { return now }
+	strict := TimestampPolicy{MaxFuture: time.Minute, MaxAge: 24 * time.Hour, RejectNonPositive: true, Now: clock}
+	clamp := strict
+	clamp.Clamp = true
+	cases := []struct {
+		in     string
+		policy TimestampPolicy
+		prec   Precision
+		ts     int64
+		err    error
+	}{
+		{"1500000000", strict, PrecisionSecond, 1500000000, nil},
+		{"1500000060", strict, PrecisionSecond, 1500000060, nil},
+		{"1500000061", strict, PrecisionSecond, 0, ErrTsTooNew},
+		{"2446685000", strict, PrecisionSecond, 0, ErrTsTooNew}, // 30 years ahead
+		{"1499913599", strict, PrecisionSecond, 0, ErrTsTooOld},
+		{"0", strict, PrecisionSecond, 0, ErrTsNotPositive},
+		{"-5", strict, PrecisionSecond, 0, ErrTsNotPositive},
+		{"0", clamp, PrecisionSecond, 0, ErrTsNotPositive},
+		{"2446685000", clamp, PrecisionSecond, 1500000060, nil},
+		{"1000", clamp, PrecisionMilli, 1499913600000, nil},
+		{"1500000060.5", clamp, PrecisionMilli, 1500000060000, nil},
+		{"0", TimestampPolicy{}, PrecisionSecond, 0, nil},
+		{"2446685000", TimestampPolicy{RejectNonPositive: true}, PrecisionSecond, 2446685000, nil},
+	}
+	for i, c := range cases {
+		ts, err := ParseTimestamp([]byte(c.in), TimestampOptions{Precision: c.prec, Policy: c.policy})
+		if err != c.err {
+			t.Fatalf("case %d: expected error %v, got %v", i, c.err, err)
+		}
+		assert.Equal(t, c.ts, ts)
+	}
+	_, err := strict.Check(1500000000, Precision(4))
+	assert.Equal(t, "unknown precision 4", err.Error())
+
+	// the policy applies to packets too
+	p, err := NewPacketParser(PacketOptions{LevelLegacy: MediumLegacy, LevelM20: MediumM20, Timestamp: TimestampOptions{Policy: strict}})
+	assert.Equal(t, nil, err)
+	_, err = p.Parse([]byte("foo.bar 1 2446685000"))
+	assert.Equal(t, ErrTsTooNew, err)
+}
@@ -0,0 +1,343 @@
+package carbon20
+
+import "strings"
+
+type stepKind int
+
+const (
+	stepSetTag stepKind = iota
+	stepReplaceTag
+	stepRenameTag
+	stepSuffixTagValue
+	stepTrimTagValueSuffix
+	stepAppendTag
+	stepPreserveTag
+	stepAddStat
+	stepReplaceTagFrom
+	stepPrefix
+)
+
+// Step is a primitive change to the tags of a metrics 2.0 metric id.
+// Steps are combined into a Transform.
+type Step struct {
+	kind     stepKind
+	key      string
+	val      string
+	policy   StatPolicy // for stepAddStat
+	from     []string   // for stepReplaceTagFrom
+	prefixes Prefixes   // for stepPrefix
+}
+
+// SetTag sets the value of the tag with the given key, appending the tag if it's not present
+func SetTag(key, val string) Step {
+	return Step{kind: stepSetTag, key: key, val: val}
+}
+
+// ReplaceTag sets the value of the tag with the given key, if it's present
+func ReplaceTag(key, val string) Step {
+	return Step{kind: stepReplaceTag, key: key, val: val}
+}
+
+// RenameTag changes the key of the tag with key from to to
+func RenameTag(from, to string) Step {
+	return Step{kind: stepRenameTag, key: from, val: to}
+}
+
+// SuffixTagValue appends suffix to the value of the tag with the given key, if it's present
+func SuffixTagValue(key, suffix string) Step {
+	return Step{kind: stepSuffixTagValue, key: key, val: suffix}
+}
+
+// TrimTagValueSuffix removes suffix from the value of the tag with the given key, if it's present
+func TrimTagValueSuffix(key, suffix string) Step {
+	return Step{kind: stepTrimTagValueSuffix, key: key, val: suffix}
+}
+
+// AppendTag appends a tag, regardless of whether a tag with the same key is present
+func AppendTag(key, val string) Step {
+	return Step{kind: stepAppendTag, key: key, val: val}
+}
+
+// PreserveTag appends a tag with key to, holding the value of the tag with key from, if it's present.
+// This is how we keep the original unit around when replacing it, e.g. orig_unit=B
+func PreserveTag(from, to string) Step {
+	return Step{kind: stepPreserveTag, key: from, val: to}
+}
+
+// ReplaceTagFrom sets the value of the tag with the given key, if it's present with one of the values in from
+func ReplaceTagFrom(key, val string, from ...string) Step {
+	return Step{kind: stepReplaceTagFrom, key: key, val: val, from: from}
+}
+
+// ReplaceMType sets the mtype tag to the given mtype, if it's present
+func ReplaceMType(m MType) Step {
+	return ReplaceTag("mtype", m.String())
+}
+
+// ReplaceMTypeFrom sets the mtype tag to the given mtype, if it's present with one of the mtypes in from
+func ReplaceMTypeFrom(m MType, from ...MType) Step {
+	vals := make([]string, len(from))
+	for i, f := range from {
+		vals[i] = f.String()
+	}
+	return ReplaceTagFrom("mtype", m.String(), vals...)
+}
+
+// SetMType sets the mtype tag to the given mtype, appending it if it's not present
+func SetMType(m MType) Step {
+	return SetTag("mtype", m.String())
+}
+
+// AddStat adds a stat tag with the given value.
+// if the metric already has a stat tag, policy decides how the stats are combined.
+// with StatError, the stat is replaced; Transform.Check is responsible for refusing such input.
+func AddStat(stat string, policy StatPolicy) Step {
+	return StackTag("stat", stat, policy)
+}
+
+// StackTag is like AddStat, for a tag with the given key, e.g. rollup=1h_of_1m
+func StackTag(key, val string, policy StatPolicy) Step {
+	return Step{kind: stepAddStat, key: key, val: val, policy: policy}
+}
+
+// AddPrefix prepends the prefix for the version of the metric, see Prefixes.
+// unlike the other steps, it also applies to legacy metrics.
+// Transform.Apply works as if AddPrefix was the last step.
+func AddPrefix(p Prefixes) Step {
+	return Step{kind: stepPrefix, prefixes: p}
+}
+
+// apply applies the step to the nodes of a metric id, which uses sep between tag keys and values
+func (s Step) apply(nodes []string, sep string) []string {
+	switch s.kind {
+	case stepAppendTag:
+		return append(nodes, s.key+sep+s.val)
+	case stepPrefix:
+		out := make([]string, 0, len(s.prefixes.tags)+len(nodes))
+		for _, tag := range s.prefixes.tags {
+			out = append(out, tag.Key+sep+tag.Value)
+		}
+		return append(out, nodes...)
+	}
+	seen := false
+	n := len(nodes)
+	for i := 0; i < n; i++ {
+		k, v, ok := splitTag(nodes[i], sep)
+		if !ok || k != s.key {
+			continue
+		}
+		seen = true
+		switch s.kind {
+		case stepSetTag, stepReplaceTag:
+			nodes[i] = k + sep + s.val
+		case stepReplaceTagFrom:
+			for _, f := range s.from {
+				if v == f {
+					nodes[i] = k + sep + s.val
+					break
+				}
+			}
+		case stepAddStat:
+			nodes[i] = k + sep + stackStat(s.val, v, s.policy)
+		case stepRenameTag:
+			nodes[i] = s.val + sep + v
+		case stepSuffixTagValue:
+			nodes[i] = nodes[i] + s.val
+		case stepTrimTagValueSuffix:
+			nodes[i] = k + sep + strings.TrimSuffix(v, s.val)
+		case stepPreserveTag:
+			nodes = append(nodes, s.val+sep+v)
+		}
+	}
+	if !seen && (s.kind == stepSetTag || s.kind == stepAddStat) {
+		nodes = append(nodes, s.key+sep+s.val)
+	}
+	return nodes
+}
+
+// Transform expresses an operation on a metric (such as deriving a counter into a rate)
+// by changing its metric id.
+// for metrics 2.0 the Steps are applied in order.
+// for legacy metrics the LegacyTrim suffix is removed if present, otherwise the LegacySuffix is appended,
+// after which the prefixes of AddPrefix steps are prepended.
+// The prefix for the given metric version is then prepended, see Prefixes.
+type Transform struct {
+	Name         string  // name of the operation, e.g. "derive_count"
+	From         []MType // mtypes the transform can be applied to. nil means any
+	RequireMType bool    // whether metrics 2.0 input must have an mtype tag
+	Steps        []Step
+	LegacySuffix string
+	LegacyTrim   string
+}
+
+// newTransform returns a transform for the given operation, which is restricted by the transitions table
+func newTransform(name, legacySuffix string, steps ...Step) Transform {
+	tr := transitions[name]
+	return Transform{
+		Name:         name,
+		From:         tr.from,
+		RequireMType: tr.required,
+		Steps:        steps,
+		LegacySuffix: legacySuffix,
+	}
+}
+
+// Then returns a transform that applies t, followed by the steps of next.
+// the LegacyTrim of next is ignored.
+func (t Transform) Then(next Transform) Transform {
+	steps := make([]Step, 0, len(t.Steps)+len(next.Steps))
+	steps = append(steps, t.Steps...)
+	steps = append(steps, next.Steps...)
+	t.Steps = steps
+	t.LegacySuffix += next.LegacySuffix
+	return t
+}
+
+// Check returns an error if the transform can't be applied to the given metric.
+// besides the From and RequireMType restrictions, input with a stat tag is rejected if the transform
+// adds a stat with StatError. unknown mtypes are only rejected if From is set, as the vocabulary
+// is not enforced otherwise, see PacketOptions.Vocabulary.
+func (t Transform) Check(in string) error {
+	err := transition{t.From, t.RequireMType}.check(t.Name, in)
+	if err != nil {
+		return err
+	}
+	for _, s := range t.Steps {
+		if s.kind == stepAddStat && s.policy == StatError {
+			if _, ok := GetTag(in, s.key); ok {
+				return errStatConflict
+			}
+		}
+	}
+	return nil
+}
+
+// Apply checks that the transform can be applied to the given metric, and applies it.
+func (t Transform) Apply(in string, p Prefixes) (string, error) {
+	err := t.Check(in)
+	if err != nil {
+		return "", err
+	}
+	return t.apply(in, p), nil
+}
+
+// apply is like Apply, but doesn't check whether the transform can be applied.
+func (t Transform) apply(in string, p Prefixes) string {
+	ver := GetVersion(in)
+	if ver == Legacy {
+		if t.LegacyTrim != "" && strings.HasSuffix(in, t.LegacyTrim) {
+			in = in[:len(in)-len(t.LegacyTrim)]
+		} else {
+			in += t.LegacySuffix
+		}
+		for _, s := range t.Steps {
+			if s.kind == stepPrefix {
+				in = s.prefixes.legacy + in
+			}
+		}
+		return p.legacy + in
+	}
+	return p.For(ver) + t.applyNodes(strings.Split(in, "."), tagSep(ver))
+}
+
+// applyNodes applies the steps to the nodes of a metrics 2.0 metric id, and joins them.
+// nodes may be modified.
+func (t Transform) applyNodes(nodes []string, sep string) string {
+	for _, s := range t.Steps {
+		nodes = s.apply(nodes, sep)
+	}
+	return strings.Join(nodes, ".")
+}
+
+// predefined transforms, on which the functions in manipulate.go are built
+
+// DeriveCountTransform derives a count or counter into a rate per second.
+// other mtypes are left alone, for when the transform is applied unchecked or composed.
+// with m1Legacy, legacy metrics keep their name.
+func DeriveCountTransform(m1Legacy bool) Transform {
+	suffix := ".rate"
+	if m1Legacy {
+		suffix = ""
+	}
+	return newTransform("derive_count", suffix, SuffixTagValue("unit", "ps"), ReplaceMTypeFrom(MTypeRate, MTypeCount, MTypeCounter))
+}
+
+// GaugeTransform leaves the metric alone, except for the prefix
+func GaugeTransform() Transform {
+	return newTransform("gauge", "")
+}
+
+// StatTransform adds a stat tag for metrics 2.0, or a node with legacyStat for legacy metrics.
+// percentile and timespec are optional and get added with underscores, e.g. stat=max_90__1m.
+// policy decides what happens to metrics 2.0 input that already has a stat tag.
+// legacy stat nodes can't be told apart from other nodes, so legacy stats are always nested by appending.
+// the percentile and timespec are not validated, see Max for functions that do.
+func StatTransform(legacyStat, stat, percentile string, timespec Timespec, policy StatPolicy) Transform {
+	return newTransform(stat, "."+statValue(legacyStat, percentile, timespec),
+		AddStat(statValue(stat, percentile, timespec), policy))
+}
+
+// CountPcktTransform counts the amount of packets received for a given thing
+func CountPcktTransform() Transform {
+	return newTransform("count_pckt", ".count",
+		PreserveTag("unit", "orig_unit"),
+		ReplaceTag("unit", "Pckt"),
+		ReplaceMType(MTypeCount),
+		AppendTag("pckt_type", "sent"),
+		AppendTag("direction", "in"),
+	)
+}
+
+// CountMetricTransform counts how many metrics were received
+func CountMetricTransform() Transform {
+	return newTransform("count_metric", ".count",
+		PreserveTag("unit", "orig_unit"),
+		ReplaceTag("unit", "Metric"),
+		ReplaceMType(MTypeCount),
+	)
+}
+
+// CountTransform counts something each interval, keeping the unit.
+// with m1Legacy, legacy metrics keep their name.
+func CountTransform(m1Legacy bool) Transform {
+	suffix := ".count"
+	if m1Legacy {
+		suffix = ""
+	}
+	return newTransform("count", suffix, SetMType(MTypeCount))
+}
+
+// CounterTransform counts something across time, keeping the unit
+func CounterTransform() Transform {
+	return newTransform("counter", ".counter", SetMType(MTypeCounter))
+}
+
+// RatePcktTransform is the rate per second of packets received for a given thing
+func RatePcktTransform() Transform {
+	return newTransform("rate_pckt", ".count_ps",
+		PreserveTag("unit", "orig_unit"),
+		ReplaceTag("unit", "Pcktps"),
+		ReplaceMType(MTypeRate),
+		AppendTag("pckt_type", "sent"),
+		AppendTag("direction", "in"),
+	)
+}
+
+// IntegrateRateTransform is the inverse of DeriveCountTransform: it turns a rate per second into a count.
+// legacy metrics lose the .rate suffix that DeriveCount adds, or get a .count suffix
+// if they don't have it. with m1Legacy, legacy metrics keep their name.
+func IntegrateRateTransform(m1Legacy bool) Transform {
+	t := newTransform("integrate_rate", ".count", TrimTagValueSuffix("unit", "ps"), ReplaceMTypeFrom(MTypeCount, MTypeRate))
+	t.LegacyTrim = ".rate"
+	if m1Legacy {
+		t.LegacySuffix, t.LegacyTrim = "", ""
+	}
+	return t
+}
+
+// IntegrateRateCumulativeTransform turns a rate per second into a counter, i.e. a running total.
+// like CounterTransform, it always gives legacy metrics a .counter suffix, so e.g. foo.rate becomes foo.rate.counter,
+// which can't be confused with the output of IntegrateRateTransform.
+func IntegrateRateCumulativeTransform() Transform {
+	return newTransform("integrate_rate_cumulative", ".counter", TrimTagValueSuffix("unit", "ps"), ReplaceMTypeFrom(MTypeCounter, MTypeRate))
+}
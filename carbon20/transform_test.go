@@ -0,0 +1,180 @@
+package carbon20
+
+import (
+	"testing"
+
+	"github.com/bmizerany/assert"
+)
+
+func TestTransformSteps(t *testing.T) {
+	cases := []struct {
+		step Step
+		in   string
+		out  string
+	}{
+		{SetTag("host", "b"), "what=rx.host=a.unit=B", "what=rx.host=b.unit=B"},
+		{SetTag("host", "b"), "what=rx.unit=B", "what=rx.unit=B.host=b"},
+		{ReplaceTag("host", "b"), "what=rx.unit=B", "what=rx.unit=B"},
+		{RenameTag("host", "server"), "what=rx.host=a.unit=B", "what=rx.server=a.unit=B"},
+		{SuffixTagValue("unit", "ps"), "what=rx.unit=B", "what=rx.unit=Bps"},
+		{TrimTagValueSuffix("unit", "ps"), "what=rx.unit=Bps", "what=rx.unit=B"},
+		{TrimTagValueSuffix("unit", "ps"), "what=rx.unit=B", "what=rx.unit=B"},
+		{AppendTag("host", "b"), "what=rx.host=a", "what=rx.host=a.host=b"},
+		{PreserveTag("unit", "orig_unit"), "unit=B.what=rx", "unit=B.what=rx.orig_unit=B"},
+		{ReplaceMType(MTypeRate), "what=rx.unit=B", "what=rx.unit=B"},
+		{SetMType(MTypeRate), "what=rx.unit=B", "what=rx.unit=B.mtype=rate"},
+		{AddStat("max", StatNest), "what=rx.unit=B", "what=rx.unit=B.stat=max"},
+		{AddStat("max", StatNest), "what=rx.stat=mean.unit=B", "what=rx.stat=max_of_mean.unit=B"},
+		{AddStat("max", StatReplace), "what=rx.stat=mean.unit=B", "what=rx.stat=max.unit=B"},
+		{SetTag("host", "b"), "what_is_rx.host_is_a", "what_is_rx.host_is_b"},
+		{RenameTag("host", "server"), "what_is_rx.host_is_a", "what_is_rx.server_is_a"},
+		{ReplaceMTypeFrom(MTypeRate, MTypeCount, MTypeCounter), "what=rx.mtype=counter", "what=rx.mtype=rate"},
+		{ReplaceMTypeFrom(MTypeRate, MTypeCount, MTypeCounter), "what=rx.mtype=gauge", "what=rx.mtype=gauge"},
+		{AddPrefix(Prefixes{}), "what=rx.unit=B", "what=rx.unit=B"},
+		{StackTag("rollup", "1h", StatNest), "what=rx.rollup=1m.unit=B", "what=rx.rollup=1h_of_1m.unit=B"},
+	}
+	for i, c := range cases {
+		tr := Transform{Name: "test", Steps: []Step{c.step}}
+		out, err := tr.Apply(c.in, Prefixes{})
+		if err != nil {
+			t.Fatalf("case %d: %s", i, err)
+		}
+		if out != c.out {
+			t.Fatalf("case %d: expected %q, got %q", i, c.out, out)
+		}
+	}
+}
+
+// a user defined operation, composed of primitive steps
+func TestTransformCustom(t *testing.T) {
+	delta := Transform{
+		Name:         "delta",
+		From:         []MType{MTypeCounter},
+		RequireMType: true,
+		Steps:        []Step{SetMType(MTypeCount), AppendTag("derived", "delta")},
+		LegacySuffix: ".delta",
+	}
+	p, err := NewPrefixes("p.", Tag{"our", "prefix"})
+	assert.Equal(t, nil, err)
+	out, err := delta.Apply("what=rx.unit=B.mtype=counter", p)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "our=prefix.what=rx.unit=B.mtype=count.derived=delta", out)
+	out, err = delta.Apply("what_is_rx.unit_is_B.mtype_is_counter", p)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "our_is_prefix.what_is_rx.unit_is_B.mtype_is_count.derived_is_delta", out)
+	out, err = delta.Apply("foo.bar", p)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "p.foo.bar.delta", out)
+	_, err = delta.Apply("what=rx.unit=B.mtype=gauge", Prefixes{})
+	assert.Equal(t, "delta can't be applied to mtype=gauge", err.Error())
+	_, err = delta.Apply("what=rx.unit=B", Prefixes{})
+	assert.Equal(t, errNoMType, err)
+
+	maxOfDelta := delta.Then(StatTransform("upper", "max", "", Timespec{}, StatNest))
+	out, err = maxOfDelta.Apply("what=rx.unit=B.mtype=counter", p)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "our=prefix.what=rx.unit=B.mtype=count.derived=delta.stat=max", out)
+	out, _ = maxOfDelta.Apply("foo.bar", p)
+	assert.Equal(t, "p.foo.bar.delta.upper", out)
+}
+
+func TestTransformAddPrefix(t *testing.T) {
+	p, err := NewPrefixes("p.", Tag{"our", "prefix"})
+	assert.Equal(t, nil, err)
+	inner, err := NewPrefixes("inner.", Tag{"env", "prod"})
+	assert.Equal(t, nil, err)
+	tr := CountTransform(false)
+	withPrefix := tr.Then(Transform{Steps: []Step{AddPrefix(p)}})
+	for _, in := range []string{"foo.bar", "what=rx.unit=B", "what_is_rx.unit_is_B"} {
+		exp, err := tr.Apply(in, p)
+		assert.Equal(t, nil, err)
+		out, err := withPrefix.Apply(in, Prefixes{})
+		assert.Equal(t, nil, err)
+		assert.Equal(t, exp, out)
+	}
+	// prefixes of earlier steps end up closer to the metric
+	nested := Transform{Name: "nested", Steps: []Step{AddPrefix(inner), AddPrefix(p)}}
+	out, err := nested.Apply("foo.bar", Prefixes{})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "p.inner.foo.bar", out)
+	out, err = nested.Apply("what_is_rx.unit_is_B", Prefixes{})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "our_is_prefix.env_is_prod.what_is_rx.unit_is_B", out)
+}
+
+func TestPredefinedTransforms(t *testing.T) {
+	p, err := NewPrefixes("p.")
+	assert.Equal(t, nil, err)
+	tags, err := NewPrefixes("p.", Tag{"our", "prefix"})
+	assert.Equal(t, nil, err)
+	cases := []struct {
+		fn  func(in string) (string, error)
+		in  string
+		out string
+	}{
+		{func(in string) (string, error) { return Count(in, p, false) }, "foo.bar", "p.foo.bar.count"},
+		{func(in string) (string, error) { return Count(in, p, true) }, "foo.bar", "p.foo.bar"},
+		{func(in string) (string, error) { return Count(in, p, false) }, "what=rx.unit=B", "what=rx.unit=B.mtype=count"},
+		{func(in string) (string, error) { return Count(in, p, false) }, "what=rx.mtype=gauge.unit=B", "what=rx.mtype=count.unit=B"},
+		{func(in string) (string, error) { return Counter(in, p) }, "foo.bar", "p.foo.bar.counter"},
+		{func(in string) (string, error) { return Counter(in, p) }, "what_is_rx.unit_is_B", "what_is_rx.unit_is_B.mtype_is_counter"},
+		{func(in string) (string, error) { return CountMetric(in, p) }, "what=rx.unit=B.mtype=gauge", "what=rx.unit=Metric.mtype=count.orig_unit=B"},
+		{func(in string) (string, error) { return Gauge(in, tags) }, "what=rx.unit=B", "our=prefix.what=rx.unit=B"},
+		{func(in string) (string, error) { return DeriveCount(in, p, false) }, "what=rx.unit=B.mtype=counter", "what=rx.unit=Bps.mtype=rate"},
+		{func(in string) (string, error) { return DeriveCount(in, p, false) }, "foo.bar", "p.foo.bar.rate"},
+		{func(in string) (string, error) { return DeriveCount(in, p, true) }, "foo.bar", "p.foo.bar"},
+		{func(in string) (string, error) { return Min(in, p, "", Timespec{1, "m"}) }, "foo.bar", "p.foo.bar.lower__1m"},
+	}
+	for i, c := range cases {
+		out, err := c.fn(c.in)
+		if err != nil || out != c.out {
+			t.Fatalf("case %d: expected %q, got %q, %v", i, c.out, out, err)
+		}
+	}
+
+	// applied unchecked, derive_count only turns counts and counters into rates
+	assert.Equal(t, "what=rx.unit=Bps.mtype=gauge", DeriveCountTransform(false).apply("what=rx.unit=B.mtype=gauge", Prefixes{}))
+}
+
+func TestIntegrateRate(t *testing.T) {
+	p, err := NewPrefixes("p.", Tag{"our", "prefix"})
+	assert.Equal(t, nil, err)
+	cases := []struct {
+		in         string
+		cumulative bool
+		m1Legacy   bool
+		out        string
+		valid      bool
+	}{
+		{"what=rx.unit=Bps.mtype=rate", false, false, "our=prefix.what=rx.unit=B.mtype=count", true},
+		{"what=rx.unit=Bps.mtype=rate", true, false, "our=prefix.what=rx.unit=B.mtype=counter", true},
+		{"what_is_rx.unit_is_Pcktps.mtype_is_rate", false, false, "our_is_prefix.what_is_rx.unit_is_Pckt.mtype_is_count", true},
+		{"foo.bar.rate", false, false, "p.foo.bar", true},
+		{"foo.bar.rate", true, false, "p.foo.bar.rate.counter", true},
+		{"foo.bar.rate", true, true, "p.foo.bar.rate.counter", true},
+		{"foo.bar", false, false, "p.foo.bar.count", true},
+		{"foo.bar", true, false, "p.foo.bar.counter", true},
+		{"foo.bar.rate", false, true, "p.foo.bar.rate", true},
+		{"what=rx.unit=B.mtype=count", false, false, "", false},
+		{"what=rx.unit=Bps", false, false, "", false},
+	}
+	for i, c := range cases {
+		out, err := IntegrateRate(c.in, p, c.m1Legacy)
+		if c.cumulative {
+			out, err = IntegrateRateCumulative(c.in, p)
+		}
+		if (err == nil) != c.valid {
+			t.Fatalf("case %d: expected valid=%t, got err=%v", i, c.valid, err)
+		}
+		assert.Equal(t, c.out, out)
+	}
+
+	// integrating a derived count gets us the original
+	for _, in := range []string{"foo.bar", "what=rx.unit=B.mtype=count", "what_is_rx.unit_is_B.mtype_is_count"} {
+		for _, m1Legacy := range []bool{false, true} {
+			out, err := IntegrateRate(must(DeriveCount(in, Prefixes{}, m1Legacy)), Prefixes{}, m1Legacy)
+			assert.Equal(t, nil, err)
+			assert.Equal(t, in, out)
+		}
+	}
+}
@@ -0,0 +1,188 @@
+package carbon20
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// OpenTelemetry and Prometheus use UCUM (https://ucum.org/ucum) style units,
+// e.g. "By", "s", "{packets}" and "By/s", while metrics 2.0 uses "B", "s", "Pckt" and "Bps".
+// UCUM units that don't have a mapping are preserved in a tag with key UCUMTagKey (see EscapeUCUM),
+// so they are not lost when converting back and forth. metrics 2.0 units without a mapping become a UCUM annotation,
+// e.g. "kReq" <-> "{kReq}".
+
+var errFmtInvalidUCUMTag = "invalid escaped ucum unit %q"
+
+// UCUMTagKey is the tag key holding a UCUM unit that has no metrics 2.0 equivalent
+const UCUMTagKey = "ucum_unit"
+
+// UnknownUnit is the unit tag value used for metrics with a UCUM unit that has no metrics 2.0 equivalent.
+// it is not a unit of the vocabulary, so ParseUnit and ConvertUnit reject it, but ValidateVocabularyM20
+// and Sanitize accept it as placeholder.
+const UnknownUnit = "Unknown"
+
+// ucumBases maps metrics 2.0 base units to UCUM units.
+// curly braces denote UCUM annotations, which are dimensionless and can't have a prefix.
+var ucumBases = map[string]string{
+	"B":      "By",
+	"b":      "bit",
+	"s":      "s",
+	"min":    "min",
+	"h":      "h",
+	"d":      "d",
+	"%":      "%",
+	"Hz":     "Hz",
+	"W":      "W",
+	"V":      "V",
+	"A":      "A",
+	"Pckt":   "{packets}",
+	"Metric": "{metrics}",
+	"Req":    "{requests}",
+	"Err":    "{errors}",
+	"Warn":   "{warnings}",
+	"Conn":   "{connections}",
+	"File":   "{files}",
+	"Job":    "{jobs}",
+	"Event":  "{events}",
+	"Msg":    "{messages}",
+	"Stmt":   "{statements}",
+	"Hit":    "{hits}",
+	"Miss":   "{misses}",
+	"Jiff":   "{jiffies}",
+	"Load":   "{load}",
+}
+
+// ucumToBase is the inverse of ucumBases
+var ucumToBase = make(map[string]string, len(ucumBases))
+
+func init() {
+	for m20, ucum := range ucumBases {
+		ucumToBase[ucum] = m20
+	}
+}
+
+// UnitToUCUM returns the UCUM equivalent of a metrics 2.0 unit.
+// e.g. "MiBps" -> "MiBy/s"
+func UnitToUCUM(unit string) (string, bool) {
+	u, err := ParseUnit(unit)
+	if err != nil {
+		return "", false
+	}
+	base, ok := ucumBases[u.Base]
+	if !ok || (u.Prefix != "" && strings.HasPrefix(base, "{")) {
+		return "", false
+	}
+	out := u.Prefix + base
+	if u.PerSecond {
+		out += "/s"
+	}
+	return out, true
+}
+
+// UnitFromUCUM returns the metrics 2.0 equivalent of a UCUM unit.
+// e.g. "MiBy/s" -> "MiBps"
+func UnitFromUCUM(ucum string) (string, bool) {
+	var u Unit
+	if strings.HasSuffix(ucum, "/s") {
+		u.PerSecond = true
+		ucum = ucum[:len(ucum)-2]
+	}
+	if base, ok := ucumToBase[ucum]; ok {
+		u.Base = base
+		return u.String(), true
+	}
+	for _, l := range []int{2, 1} {
+		if len(ucum) <= l {
+			continue
+		}
+		if _, ok := unitPrefixes[ucum[:l]]; !ok {
+			continue
+		}
+		base, ok := ucumToBase[ucum[l:]]
+		if ok && unitBases[base] && !strings.HasPrefix(ucum[l:], "{") {
+			u.Prefix, u.Base = ucum[:l], base
+			return u.String(), true
+		}
+	}
+	return "", false
+}
+
+// EscapeUCUM escapes a UCUM unit for use as the value of the UCUMTagKey tag.
+// all characters but letters, digits and - become _ followed by two hex digits, e.g. "kg.m/s2" -> "kg_2em_2fs2".
+func EscapeUCUM(ucum string) string {
+	var b strings.Builder
+	for i := 0; i < len(ucum); i++ {
+		c := ucum[i]
+		if (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9') || c == '-' {
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&b, "_%02x", c)
+	}
+	return b.String()
+}
+
+// UnescapeUCUM is the inverse of EscapeUCUM
+func UnescapeUCUM(s string) (string, error) {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '_' {
+			b.WriteByte(s[i])
+			continue
+		}
+		if i+3 > len(s) {
+			return "", fmt.Errorf(errFmtInvalidUCUMTag, s)
+		}
+		c, err := strconv.ParseUint(s[i+1:i+3], 16, 8)
+		if err != nil {
+			return "", fmt.Errorf(errFmtInvalidUCUMTag, s)
+		}
+		b.WriteByte(byte(c))
+		i += 2
+	}
+	return b.String(), nil
+}
+
+// ucumFromTags returns the UCUM unit for a metric with the given unit and UCUMTagKey tag values.
+// the UCUM unit from the tag takes precedence. metrics 2.0 units without a mapping become a UCUM annotation.
+func ucumFromTags(unit, ucum string) (string, error) {
+	if ucum != "" {
+		return UnescapeUCUM(ucum)
+	}
+	if unit == "" {
+		return "", nil
+	}
+	if out, ok := UnitToUCUM(unit); ok {
+		return out, nil
+	}
+	return "{" + unit + "}", nil
+}
+
+// unitFromUCUMTag returns the metrics 2.0 unit for the given UCUM unit,
+// and the escaped value for the UCUMTagKey tag, if the unit can't be mapped, in which case the unit is UnknownUnit.
+// the content of annotations becomes the unit, if it can, as ucumFromTags turns unmapped units into annotations.
+func unitFromUCUMTag(ucum string) (unit, tag string) {
+	if ucum == "" {
+		return "", ""
+	}
+	if unit, ok := UnitFromUCUM(ucum); ok {
+		return unit, ""
+	}
+	if len(ucum) > 2 && ucum[0] == '{' && ucum[len(ucum)-1] == '}' {
+		if annotation := ucum[1 : len(ucum)-1]; isAnnotationUnit(annotation) {
+			return annotation, ""
+		}
+	}
+	return UnknownUnit, EscapeUCUM(ucum)
+}
+
+// isAnnotationUnit returns whether the content of a UCUM annotation can be used as metrics 2.0 unit as-is
+func isAnnotationUnit(s string) bool {
+	for _, r := range s {
+		if r == '.' || !sensibleRune(r) {
+			return false
+		}
+	}
+	return !strings.Contains(s, "_is_")
+}
@@ -0,0 +1,67 @@
+package carbon20
+
+import (
+	"testing"
+
+	"github.com/bmizerany/assert"
+)
+
+func TestUCUM(t *testing.T) {
+	cases := []struct {
+		m20  string
+		ucum string
+	}{
+		{"B", "By"},
+		{"b", "bit"},
+		{"Bps", "By/s"},
+		{"MiB", "MiBy"},
+		{"kbps", "kbit/s"},
+		{"ms", "ms"},
+		{"s", "s"},
+		{"Pckt", "{packets}"},
+		{"Pcktps", "{packets}/s"},
+		{"Req", "{requests}"},
+		{"%", "%"},
+	}
+	for _, c := range cases {
+		ucum, ok := UnitToUCUM(c.m20)
+		assert.Equal(t, true, ok)
+		assert.Equal(t, c.ucum, ucum)
+		m20, ok := UnitFromUCUM(c.ucum)
+		assert.Equal(t, true, ok)
+		assert.Equal(t, c.m20, m20)
+	}
+	for _, in := range []string{"bytes", "kPckt", ""} {
+		_, ok := UnitToUCUM(in)
+		assert.Equal(t, false, ok)
+	}
+	for _, in := range []string{"kg.m/s2", "k{packets}", "1", "{foo}", ""} {
+		_, ok := UnitFromUCUM(in)
+		assert.Equal(t, false, ok)
+	}
+}
+
+func TestEscapeUCUM(t *testing.T) {
+	cases := []struct {
+		ucum    string
+		escaped string
+	}{
+		{"By", "By"},
+		{"kg.m/s2", "kg_2em_2fs2"},
+		{"{foo}", "_7bfoo_7d"},
+		{"a_is_b", "a_5fis_5fb"},
+		{"%", "_25"},
+	}
+	for _, c := range cases {
+		assert.Equal(t, c.escaped, EscapeUCUM(c.ucum))
+		ucum, err := UnescapeUCUM(c.escaped)
+		assert.Equal(t, nil, err)
+		assert.Equal(t, c.ucum, ucum)
+	}
+	for _, in := range []string{"_", "_7", "_zz"} {
+		_, err := UnescapeUCUM(in)
+		if err == nil {
+			t.Fatalf("UnescapeUCUM(%q): expected error", in)
+		}
+	}
+}
@@ -0,0 +1,296 @@
+package carbon20
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var errEmptyUnit = errors.New("empty unit")
+
+// Unit is a parsed metrics 2.0 unit. e.g. "MiBps" is {Prefix: "Mi", Base: "B", PerSecond: true}
+// see http://metrics20.org/spec/#units
+type Unit struct {
+	Prefix    string
+	Base      string
+	PerSecond bool
+}
+
+// String returns the unit in its metrics 2.0 notation
+func (u Unit) String() string {
+	if u.PerSecond {
+		return u.Prefix + u.Base + "ps"
+	}
+	return u.Prefix + u.Base
+}
+
+// unitBases are the base units we understand. the value indicates whether prefixes may be applied.
+var unitBases = map[string]bool{
+	"B":      true, // bytes
+	"b":      true, // bits
+	"Pckt":   true, // packets
+	"Metric": true, // metrics
+	"Req":    true, // requests
+	"Err":    true, // errors
+	"Warn":   true, // warnings
+	"Conn":   true, // connections
+	"File":   true, // files
+	"Job":    true, // jobs
+	"Event":  true, // events
+	"Msg":    true, // messages
+	"Stmt":   true, // (database) statements
+	"Hit":    true, // (cache) hits
+	"Miss":   true, // (cache) misses
+	"Jiff":   true, // jiffies
+	"Load":   true, // load average
+	"s":      true, // seconds
+	"Hz":     true, // hertz
+	"W":      true, // watts
+	"V":      true, // volts
+	"A":      true, // ampere
+	"min":    false,
+	"h":      false,
+	"d":      false,
+	"%":      false,
+}
+
+// unitPrefixes maps SI and IEC prefixes to their multiplier.
+var unitPrefixes = map[string]float64{
+	"n":  1e-9,
+	"u":  1e-6,
+	"m":  1e-3,
+	"k":  1e3,
+	"M":  1e6,
+	"G":  1e9,
+	"T":  1e12,
+	"P":  1e15,
+	"E":  1e18,
+	"Ki": 1 << 10,
+	"Mi": 1 << 20,
+	"Gi": 1 << 30,
+	"Ti": 1 << 40,
+	"Pi": 1 << 50,
+	"Ei": 1 << 60,
+}
+
+// unitAliases maps commonly used, but non-standard unit names to their metrics 2.0 equivalent.
+// keys are lowercase.
+var unitAliases = map[string]string{
+	"byte":         "B",
+	"bytes":        "B",
+	"bit":          "b",
+	"bits":         "b",
+	"packet":       "Pckt",
+	"packets":      "Pckt",
+	"pkt":          "Pckt",
+	"pkts":         "Pckt",
+	"metrics":      "Metric",
+	"request":      "Req",
+	"requests":     "Req",
+	"reqs":         "Req",
+	"error":        "Err",
+	"errors":       "Err",
+	"errs":         "Err",
+	"warning":      "Warn",
+	"warnings":     "Warn",
+	"connection":   "Conn",
+	"connections":  "Conn",
+	"conns":        "Conn",
+	"files":        "File",
+	"jobs":         "Job",
+	"events":       "Event",
+	"message":      "Msg",
+	"messages":     "Msg",
+	"msgs":         "Msg",
+	"statement":    "Stmt",
+	"statements":   "Stmt",
+	"hits":         "Hit",
+	"misses":       "Miss",
+	"jiffies":      "Jiff",
+	"sec":          "s",
+	"secs":         "s",
+	"second":       "s",
+	"seconds":      "s",
+	"msec":         "ms",
+	"millisecond":  "ms",
+	"milliseconds": "ms",
+	"usec":         "us",
+	"microseconds": "us",
+	"nanoseconds":  "ns",
+	"minute":       "min",
+	"minutes":      "min",
+	"hour":         "h",
+	"hours":        "h",
+	"day":          "d",
+	"days":         "d",
+	"percent":      "%",
+	"pct":          "%",
+	"hertz":        "Hz",
+	"watt":         "W",
+	"watts":        "W",
+	"volt":         "V",
+	"volts":        "V",
+	"kilobytes":    "kB",
+	"megabytes":    "MB",
+	"gigabytes":    "GB",
+}
+
+// UnknownUnitError is returned for units that are not in the metrics 2.0 vocabulary.
+// Suggestion holds the standard unit that was likely meant, if we know one.
+type UnknownUnitError struct {
+	Unit       string
+	Suggestion string
+}
+
+func (e UnknownUnitError) Error() string {
+	if e.Suggestion != "" {
+		return fmt.Sprintf("unknown unit %q (did you mean %q?)", e.Unit, e.Suggestion)
+	}
+	return fmt.Sprintf("unknown unit %q", e.Unit)
+}
+
+// parseUnitNoPs parses a unit without looking for the ps suffix.
+func parseUnitNoPs(s string) (Unit, bool) {
+	if _, ok := unitBases[s]; ok {
+		return Unit{Base: s}, true
+	}
+	// try the two character (IEC) prefixes before the single character (SI) ones
+	for _, l := range []int{2, 1} {
+		if len(s) <= l {
+			continue
+		}
+		if _, ok := unitPrefixes[s[:l]]; !ok {
+			continue
+		}
+		if prefixable, ok := unitBases[s[l:]]; ok && prefixable {
+			return Unit{Prefix: s[:l], Base: s[l:]}, true
+		}
+	}
+	return Unit{}, false
+}
+
+// ParseUnit parses a metrics 2.0 unit, made up of an optional SI or IEC prefix,
+// a base unit and an optional "ps" (per second) suffix.
+// unknown units result in an UnknownUnitError.
+func ParseUnit(s string) (Unit, error) {
+	if s == "" {
+		return Unit{}, errEmptyUnit
+	}
+	if u, ok := parseUnitNoPs(s); ok {
+		return u, nil
+	}
+	if strings.HasSuffix(s, "ps") {
+		if u, ok := parseUnitNoPs(s[:len(s)-2]); ok {
+			u.PerSecond = true
+			return u, nil
+		}
+	}
+	sug, _ := SuggestUnit(s)
+	return Unit{}, UnknownUnitError{s, sug}
+}
+
+// SuggestUnit returns the standard metrics 2.0 unit for a commonly used alternative spelling,
+// such as "bytes" -> "B" or "requestsps" -> "Reqps".
+func SuggestUnit(s string) (string, bool) {
+	if _, ok := parseUnitNoPs(s); ok {
+		return s, true
+	}
+	lower := strings.ToLower(s)
+	if sug, ok := unitAliases[lower]; ok {
+		return sug, true
+	}
+	for _, suffix := range []string{"ps", "_per_sec", "_per_second", "/s"} {
+		if strings.HasSuffix(lower, suffix) {
+			if sug, ok := unitAliases[lower[:len(lower)-len(suffix)]]; ok {
+				return sug + "ps", true
+			}
+			if _, ok := unitBases[s[:len(s)-len(suffix)]]; ok {
+				return s[:len(s)-len(suffix)] + "ps", true
+			}
+		}
+	}
+	// wrong case, e.g. "pckt" or "REQ"
+	for base := range unitBases {
+		if strings.EqualFold(base, s) {
+			return base, true
+		}
+	}
+	return "", false
+}
+
+// ValidateUnit returns an error if the given unit is not in the metrics 2.0 vocabulary
+func ValidateUnit(s string) error {
+	_, err := ParseUnit(s)
+	return err
+}
+
+// validateVocabularyUnit is ValidateUnit for the unit tag of a metric, which may also be UnknownUnit
+func validateVocabularyUnit(s string) error {
+	if s == UnknownUnit {
+		return nil
+	}
+	return ValidateUnit(s)
+}
+
+var errIncompatibleUnits = errors.New("units have incompatible dimensions")
+
+// unitDimensions maps base units to the base unit of their dimension, and the factor to convert to it.
+// base units that are not listed are only compatible with themselves.
+var unitDimensions = map[string]struct {
+	base   string
+	factor float64
+}{
+	"b":   {"b", 1},
+	"B":   {"b", 8},
+	"s":   {"s", 1},
+	"min": {"s", 60},
+	"h":   {"s", 3600},
+	"d":   {"s", 86400},
+}
+
+// factor returns the dimension base of the unit, and the factor to convert to it
+func (u Unit) factor() (string, float64) {
+	base, f := u.Base, 1.0
+	if dim, ok := unitDimensions[u.Base]; ok {
+		base, f = dim.base, dim.factor
+	}
+	if u.Prefix != "" {
+		f *= unitPrefixes[u.Prefix]
+	}
+	return base, f
+}
+
+// UnitFactor returns the factor to multiply values in unit from with, to express them in unit to.
+// e.g. UnitFactor("B", "b") is 8.
+func UnitFactor(from, to string) (float64, error) {
+	uFrom, err := ParseUnit(from)
+	if err != nil {
+		return 0, err
+	}
+	uTo, err := ParseUnit(to)
+	if err != nil {
+		return 0, err
+	}
+	baseFrom, fFrom := uFrom.factor()
+	baseTo, fTo := uTo.factor()
+	if baseFrom != baseTo || uFrom.PerSecond != uTo.PerSecond {
+		return 0, fmt.Errorf("%s: %q and %q", errIncompatibleUnits, from, to)
+	}
+	return fFrom / fTo, nil
+}
+
+// ConvertUnit expresses a value of the given metric in targetUnit.
+// It returns the metric with the unit tag set to targetUnit, and the scaled value.
+// e.g. ("foo=bar.unit=B.mtype=gauge", 2, "b") -> ("foo=bar.unit=b.mtype=gauge", 16)
+func ConvertUnit(metric string, value float64, targetUnit string) (string, float64, error) {
+	unit, ok := GetTag(metric, "unit")
+	if !ok {
+		return metric, value, errNoUnit
+	}
+	factor, err := UnitFactor(unit, targetUnit)
+	if err != nil {
+		return metric, value, err
+	}
+	out, _ := replaceTag(metric, "unit", targetUnit)
+	return out, value * factor, nil
+}
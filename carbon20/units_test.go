@@ -0,0 +1,138 @@
+package carbon20
+
+import (
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/bmizerany/assert"
+)
+
+func TestParseUnit(t *testing.T) {
+	cases := []struct {
+		in    string
+		unit  Unit
+		valid bool
+	}{
+		{"B", Unit{"", "B", false}, true},
+		{"b", Unit{"", "b", false}, true},
+		{"Bps", Unit{"", "B", true}, true},
+		{"MiBps", Unit{"Mi", "B", true}, true},
+		{"kb", Unit{"k", "b", false}, true},
+		{"Mbps", Unit{"M", "b", true}, true},
+		{"Metric", Unit{"", "Metric", false}, true},
+		{"Pcktps", Unit{"", "Pckt", true}, true},
+		{"ms", Unit{"m", "s", false}, true},
+		{"min", Unit{"", "min", false}, true},
+		{"%", Unit{"", "%", false}, true},
+		{"ps", Unit{"p", "s", false}, false}, // we don't do pico
+		{"k%", Unit{}, false},
+		{"bytes", Unit{}, false},
+		{"yes", Unit{}, false},
+		{"", Unit{}, false},
+	}
+	for i, c := range cases {
+		u, err := ParseUnit(c.in)
+		if (err == nil) != c.valid {
+			t.Fatalf("case %d: ParseUnit(%q): expected valid=%t, got err=%v", i, c.in, c.valid, err)
+		}
+		if c.valid {
+			assert.Equal(t, c.unit, u)
+			assert.Equal(t, c.in, u.String())
+		}
+	}
+}
+
+func TestSuggestUnit(t *testing.T) {
+	cases := []struct {
+		in  string
+		out string
+	}{
+		{"bytes", "B"},
+		{"Bytes", "B"},
+		{"bytes/s", "Bps"},
+		{"requests_per_sec", "Reqps"},
+		{"pckt", "Pckt"},
+		{"B", "B"},
+		{"foo", ""},
+		{"unknown", ""},
+	}
+	for _, c := range cases {
+		sug, ok := SuggestUnit(c.in)
+		assert.Equal(t, c.out, sug)
+		assert.Equal(t, c.out != "", ok)
+	}
+	assert.Equal(t, UnknownUnitError{"bytes", "B"}, ValidateUnit("bytes"))
+	assert.Equal(t, `unknown unit "bytes" (did you mean "B"?)`, ValidateUnit("bytes").Error())
+}
+
+func TestValidateVocabularyM20(t *testing.T) {
+	cases := []struct {
+		in    string
+		valid bool
+	}{
+		{"what=rx.unit=B.mtype=counter", true},
+		{"what=rx.unit=MiBps.mtype=rate", true},
+		{"unit=Pckt.what=rx.mtype=count", true},
+		{"what=rx.aunit=B.mtype=counter", true},
+		{"what=rx.unit=bytes.mtype=counter", false},
+		{"what=rx.unit=yes.mtype=counter", false},
+		{"what=rx.unit=.mtype=counter", false},
+		{"what=rx.unit=Unknown.mtype=counter", true},
+	}
+	for _, c := range cases {
+		assert.Equal(t, c.valid, ValidateVocabularyM20(c.in) == nil)
+		assert.Equal(t, c.valid, ValidateVocabularyM20B([]byte(c.in)) == nil)
+		ne := strings.Replace(c.in, "=", "_is_", -1)
+		assert.Equal(t, c.valid, ValidateVocabularyM20(ne) == nil)
+		assert.Equal(t, c.valid, ValidateVocabularyM20B([]byte(ne)) == nil)
+	}
+	assert.Equal(t, nil, ValidateVocabularyM20("foo.bar.bytes"))
+
+	// UnknownUnit is only a placeholder
+	assert.Equal(t, UnknownUnitError{UnknownUnit, ""}, ValidateUnit(UnknownUnit))
+	_, _, err := ConvertUnit("what=rx.unit=Unknown.mtype=gauge", 1, "B")
+	assert.NotEqual(t, nil, err)
+
+	// the vocabulary is not part of the validation levels
+	assert.Equal(t, nil, ValidateKeyM20("what=rx.unit=yes.mtype=counter", StrictM20))
+	assert.Equal(t, nil, ValidateKeyM20NoEqualsB([]byte("what_is_rx.unit_is_yes.mtype_is_counter"), StrictM20))
+}
+
+func TestConvertUnit(t *testing.T) {
+	cases := []struct {
+		in     string
+		value  float64
+		target string
+		out    string
+		outVal float64
+		valid  bool
+	}{
+		{"what=rx.unit=B.mtype=gauge", 2, "b", "what=rx.unit=b.mtype=gauge", 16, true},
+		{"what=rx.unit=MiB.mtype=gauge", 2, "B", "what=rx.unit=B.mtype=gauge", 2 * 1024 * 1024, true},
+		{"what=rx.unit=B.mtype=gauge", 2048, "KiB", "what=rx.unit=KiB.mtype=gauge", 2, true},
+		{"what=lat.unit=ms.mtype=gauge", 1500, "s", "what=lat.unit=s.mtype=gauge", 1.5, true},
+		{"what=up.unit=h.mtype=gauge", 2, "min", "what=up.unit=min.mtype=gauge", 120, true},
+		{"what_is_rx.unit_is_Bps.mtype_is_rate", 1e6, "Mbps", "what_is_rx.unit_is_Mbps.mtype_is_rate", 8, true},
+		{"what=rx.unit=Reqps.mtype=rate", 3, "kReqps", "what=rx.unit=kReqps.mtype=rate", 0.003, true},
+		{"what=rx.unit=Bps.mtype=rate", 1, "b", "", 0, false},
+		{"what=rx.unit=B.mtype=gauge", 1, "s", "", 0, false},
+		{"what=rx.unit=B.mtype=gauge", 1, "bytes", "", 0, false},
+		{"what=rx.unit=yes.mtype=gauge", 1, "B", "", 0, false},
+		{"what=rx.mtype=gauge", 1, "B", "", 0, false},
+		{"foo.bar", 1, "B", "", 0, false},
+	}
+	for i, c := range cases {
+		out, val, err := ConvertUnit(c.in, c.value, c.target)
+		if (err == nil) != c.valid {
+			t.Fatalf("case %d: ConvertUnit(%q, %v, %q): expected valid=%t, got err=%v", i, c.in, c.value, c.target, c.valid, err)
+		}
+		if !c.valid {
+			continue
+		}
+		assert.Equal(t, c.out, out)
+		if math.Abs(val-c.outVal) > 1e-9 {
+			t.Fatalf("case %d: expected value %v, got %v", i, c.outVal, val)
+		}
+	}
+}
@@ -4,8 +4,9 @@ import (
 	"bytes"
 	"errors"
 	"fmt"
-	"strconv"
 	"strings"
+	"unicode"
+	"unicode/utf8"
 )
 
 var errTooManyEquals = errors.New("more than 1 equals")
@@ -24,8 +25,13 @@ var errInvalidTagAppendix = errors.New("invalid tag appendix")
 var errFmtNullAt = "null byte at position %d"
 var errFmtIllegalChar = "illegal char %q"
 var errFmtNonAsciiChar = "non-ASCII char %q"
+var errFmtInvalidUTF8At = "invalid UTF-8 at position %d"
+var errFmtControlChar = "control char %q"
 
-// ValidationLevelLegacy indicates the level of validation to undertake for legacy metrics
+// ValidationLevelLegacy indicates the level of validation to undertake for legacy metrics.
+// UTF8Legacy was added after the others, so the values are not ordered by strictness: from strict to lenient,
+// the levels are StrictLegacy, MediumLegacy, UTF8Legacy and NoneLegacy. UTF8Legacy accepts all of UTF-8 where
+// MediumLegacy only accepts ASCII, but unlike MediumLegacy it rejects control characters.
 //go:generate stringer -type=ValidationLevelLegacy
 type ValidationLevelLegacy int
 
@@ -33,16 +39,20 @@ const (
 	StrictLegacy ValidationLevelLegacy = iota // Sensible character validation and no consecutive dots
 	MediumLegacy                              // Ensure characters are 8-bit clean and not NULL
 	NoneLegacy                                // No validation
+	UTF8Legacy                                // Like MediumLegacy, but allow well-formed UTF-8 that has no control characters
 )
 
-// ValidationLevelM20 indicates validation level for both M20 and M20NoEquals types
+// ValidationLevelM20 indicates validation level for both M20 and M20NoEquals types.
+// UTF8M20 was added after the others, so the values are not ordered by strictness: from strict to lenient,
+// the levels are StrictM20, UTF8M20, MediumM20 and NoneM20. MediumM20 doesn't check characters at all.
 //go:generate stringer -type=ValidationLevelM20
 type ValidationLevelM20 int
 
 const (
-	StrictM20 ValidationLevelM20 = iota // not implemented. reserved for if a nead appears
+	StrictM20 ValidationLevelM20 = iota // like UTF8M20 for now. reserved for stricter checks if a need appears
 	MediumM20                           // unit, mtype tag set. no mixing of = and _is_ styles. at least two tags.
 	NoneM20
+	UTF8M20 // medium, plus the metric must be well-formed UTF-8 without control characters or NULL
 )
 
 // helper functions
@@ -83,6 +93,66 @@ func validateNotNullAsciiChars(metric_id []byte) error {
 	return nil
 }
 
+// validateUTF8Chars checks that metric_id is well-formed UTF-8, and has no NULL bytes or other control characters
+func validateUTF8Chars(metric_id string) error {
+	for i, ch := range metric_id {
+		if ch == utf8.RuneError {
+			if _, size := utf8.DecodeRuneInString(metric_id[i:]); size == 1 {
+				return fmt.Errorf(errFmtInvalidUTF8At, i)
+			}
+		}
+		if ch == 0 {
+			return fmt.Errorf(errFmtNullAt, i)
+		}
+		if unicode.IsControl(ch) {
+			return fmt.Errorf(errFmtControlChar, ch)
+		}
+	}
+	return nil
+}
+
+// validateUTF8CharsB is like validateUTF8Chars but for byte array inputs.
+// ASCII is checked byte by byte, only other characters are decoded.
+func validateUTF8CharsB(metric_id []byte) error {
+	for i := 0; i < len(metric_id); {
+		ch := metric_id[i]
+		if ch < utf8.RuneSelf {
+			if ch == 0 {
+				return fmt.Errorf(errFmtNullAt, i)
+			}
+			if ch < 0x20 || ch == 0x7f {
+				return fmt.Errorf(errFmtControlChar, rune(ch))
+			}
+			i++
+			continue
+		}
+		r, size := utf8.DecodeRune(metric_id[i:])
+		if r == utf8.RuneError && size == 1 {
+			return fmt.Errorf(errFmtInvalidUTF8At, i)
+		}
+		if unicode.IsControl(r) {
+			return fmt.Errorf(errFmtControlChar, r)
+		}
+		i += size
+	}
+	return nil
+}
+
+// validateVocabulary checks that unit and mtype tag values, if present, are in the metrics 2.0 vocabulary
+func validateVocabulary(unit, mtype []byte) error {
+	if unit != nil {
+		err := validateVocabularyUnit(string(unit))
+		if err != nil {
+			return err
+		}
+	}
+	if mtype != nil {
+		_, err := ParseMType(string(mtype))
+		return err
+	}
+	return nil
+}
+
 // public functions
 
 // ValidateKeyLegacy checks the basic form of metric keys
@@ -117,6 +187,9 @@ func ValidateKeyLegacy(metric_id string, level ValidationLevelLegacy) error {
 			return err
 		}
 	}
+	if level == UTF8Legacy {
+		return validateUTF8Chars(metric_id) // including the appendix
+	}
 	return validateNotNullAsciiChars([]byte(metric_id)) // including the appendix
 }
 func ValidateKeyM20(metric_id string, level ValidationLevelM20) error {
@@ -135,6 +208,9 @@ func ValidateKeyM20(metric_id string, level ValidationLevelM20) error {
 	if strings.Count(metric_id, ".") < 2 {
 		return errNotEnoughTags
 	}
+	if level == StrictM20 || level == UTF8M20 {
+		return validateUTF8Chars(metric_id)
+	}
 	return nil
 }
 func ValidateKeyM20NoEquals(metric_id string, level ValidationLevelM20) error {
@@ -153,6 +229,9 @@ func ValidateKeyM20NoEquals(metric_id string, level ValidationLevelM20) error {
 	if strings.Count(metric_id, ".") < 2 {
 		return errNotEnoughTags
 	}
+	if level == StrictM20 || level == UTF8M20 {
+		return validateUTF8Chars(metric_id)
+	}
 	return nil
 }
 
@@ -203,6 +282,9 @@ func ValidateKeyLegacyB(metric_id []byte, level ValidationLevelLegacy) error {
 			return err
 		}
 	}
+	if level == UTF8Legacy {
+		return validateUTF8CharsB(metric_id) // including the appendix
+	}
 	return validateNotNullAsciiChars([]byte(metric_id)) // including the appendix
 }
 
@@ -222,6 +304,9 @@ func ValidateKeyM20B(metric_id []byte, level ValidationLevelM20) error {
 	if bytes.Count(metric_id, dot) < 2 {
 		return errNotEnoughTags
 	}
+	if level == StrictM20 || level == UTF8M20 {
+		return validateUTF8CharsB(metric_id)
+	}
 	return nil
 }
 func ValidateKeyM20NoEqualsB(metric_id []byte, level ValidationLevelM20) error {
@@ -240,52 +325,43 @@ func ValidateKeyM20NoEqualsB(metric_id []byte, level ValidationLevelM20) error {
 	if bytes.Count(metric_id, dot) < 2 {
 		return errNotEnoughTags
 	}
+	if level == StrictM20 || level == UTF8M20 {
+		return validateUTF8CharsB(metric_id)
+	}
 	return nil
 }
 
-var space = []byte(" ")
-var empty = []byte("")
-
-// ValidatePacket validates a carbon message and returns useful pieces of it
-func ValidatePacket(buf []byte, levelLegacy ValidationLevelLegacy, levelM20 ValidationLevelM20) ([]byte, float64, uint32, error) {
-	fields := bytes.Fields(buf)
-	if len(fields) != 3 {
-		return empty, 0, 0, errWrongNumFields
-	}
-
-	version := GetVersionB(fields[0])
-	var err error
-
-	// graphite graciously allows a leading dot by pretending it's not there.
-	// (e.g. send ".foo" -> metric will become "foo") so we do the same.
-	// see https://github.com/grafana/metrictank/issues/668 and
-	// https://github.com/grafana/metrictank/issues/694
-	if len(fields[0]) != 0 && fields[0][0] == '.' {
-		fields[0] = fields[0][1:]
-	}
+// ValidateVocabularyM20 checks that the unit and mtype tags of a metrics 2.0 metric, if present,
+// are in the metrics 2.0 vocabulary (see ParseUnit, ParseMType).
+// It is an optional check, on top of the validation levels. Legacy metrics have no vocabulary, so they always pass.
+func ValidateVocabularyM20(metric_id string) error {
+	return ValidateVocabularyM20B([]byte(metric_id))
+}
 
-	if version == Legacy {
-		err = ValidateKeyLegacyB(fields[0], levelLegacy)
-	} else if version == M20 {
-		err = ValidateKeyM20B(fields[0], levelM20)
-	} else { // version == M20NoEquals
-		err = ValidateKeyM20NoEqualsB(fields[0], levelM20)
-	}
-	if err != nil {
-		return fields[0], 0, 0, err
+// ValidateVocabularyM20B is like ValidateVocabularyM20 but for byte array inputs.
+func ValidateVocabularyM20B(metric_id []byte) error {
+	unitPre, mtypePre := m20UnitPre, m20MTPre
+	if bytes.IndexByte(metric_id, '=') < 0 {
+		if !bytes.Contains(metric_id, m20Is) {
+			return nil
+		}
+		unitPre, mtypePre = m20NEUnitPre, m20NEMTPre
 	}
+	return validateVocabulary(tagValueB(metric_id, unitPre), tagValueB(metric_id, mtypePre))
+}
 
-	val, err := strconv.ParseFloat(string(fields[1]), 64)
-	if err != nil {
-		return fields[0], 0, 0, errValNotNumber
-	}
+var space = []byte(" ")
+var empty = []byte("")
 
-	ts, err := strconv.ParseFloat(string(fields[2]), 64)
+// ValidatePacket validates a carbon message and returns useful pieces of it.
+// it is a wrapper around PacketParser, which offers more options.
+func ValidatePacket(buf []byte, levelLegacy ValidationLevelLegacy, levelM20 ValidationLevelM20) ([]byte, float64, uint32, error) {
+	p := PacketParser{opts: PacketOptions{LevelLegacy: levelLegacy, LevelM20: levelM20}}
+	pkt, err := p.Parse(buf)
 	if err != nil {
-		return fields[0], 0, 0, errTsNotTs
+		return pkt.Key, 0, 0, err
 	}
-
-	return fields[0], val, uint32(ts), nil
+	return pkt.Key, pkt.Value.Float64(), uint32(pkt.Ts), nil
 }
 
 // ValidateTagAppendix returns whether a tags appendix is in a valid format.
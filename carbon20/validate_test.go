@@ -1,6 +1,7 @@
 package carbon20
 
 import (
+	"fmt"
 	"math"
 	"testing"
 
@@ -56,6 +57,24 @@ func TestValidateLegacy(t *testing.T) {
 		{"foo.bar;k=\x00z", StrictLegacy, false},
 		{"foo.bar;k=\x00z", MediumLegacy, false},
 		{"foo.bar;k=\x00z", NoneLegacy, true},
+
+		// UTF8Legacy is MediumLegacy, but allows well-formed UTF-8 and rejects control characters
+		{"foo..bar.ba::z", UTF8Legacy, true},
+		{"zürich.straße.東京", UTF8Legacy, true},
+		{"zürich.straße.東京", MediumLegacy, false},
+		{"foo.bar;city=zürich", UTF8Legacy, true},
+		{"foo.bar;city=zürich", MediumLegacy, false},
+		{"foo.bar;cïty!=zürich", UTF8Legacy, false},
+		{"foo..bar.b\xbdz", UTF8Legacy, false},
+		{"foo.bar.\xe6\x9d", UTF8Legacy, false},
+		{"foo..bar.b\x00z", UTF8Legacy, false},
+		{"foo.bar.b\tz", UTF8Legacy, false},
+		{"foo.bar.b\tz", MediumLegacy, true},
+		{"foo.bar.b\x7fz", UTF8Legacy, false},
+		{"foo.bar.b\u0085z", UTF8Legacy, false},
+		{"foo.bar;k=\xbdz", UTF8Legacy, false},
+		{"foo.bar;k=\x01z", UTF8Legacy, false},
+		{"foo.bar.\ufffd", UTF8Legacy, true},
 	}
 	for i, c := range cases {
 		err := ValidateKeyLegacy(c.in, c.level)
@@ -127,6 +146,12 @@ func TestValidateM20(t *testing.T) {
 		{"foo.bar.aunit=no.baz", NoneM20, true},
 		{"foo.bar.UNIT=no.baz", NoneM20, true},
 		{"foo.bar.unita=no.bar", NoneM20, true},
+		{"what=zürich.unit=B.mtype=gauge", UTF8M20, true},
+		{"what=zürich.unit=B.mtype=gauge", MediumM20, true},
+		{"what=z\xbdrich.unit=B.mtype=gauge", UTF8M20, false},
+		{"what=z\x00rich.unit=B.mtype=gauge", UTF8M20, false},
+		{"what=z\nrich.unit=B.mtype=gauge", UTF8M20, false},
+		{"what=zürich.unit=B", UTF8M20, false},
 	}
 	for _, c := range cases {
 		assert.Equal(t, ValidateKeyM20(c.in, c.level) == nil, c.valid)
@@ -145,6 +170,9 @@ func TestValidateM20NoEquals(t *testing.T) {
 		{"foo.bar.mtype_is_count.baz", NoneM20, true},
 		{"foo.bar.mtype_is_count", NoneM20, true},
 		{"mtype_is_count.foo.bar", NoneM20, true},
+		{"what_is_zürich.unit_is_B.mtype_is_gauge", UTF8M20, true},
+		{"what_is_z\xbdrich.unit_is_B.mtype_is_gauge", UTF8M20, false},
+		{"what_is_z\x1brich.unit_is_B.mtype_is_gauge", UTF8M20, false},
 	}
 	for _, c := range cases {
 		assert.Equal(t, ValidateKeyM20NoEquals(c.in, c.level) == nil, c.valid)
@@ -152,6 +180,41 @@ func TestValidateM20NoEquals(t *testing.T) {
 	}
 }
 
+func TestValidationLevelString(t *testing.T) {
+	assert.Equal(t, "StrictLegacy,MediumLegacy,NoneLegacy,UTF8Legacy,ValidationLevelLegacy(4)",
+		fmt.Sprintf("%s,%s,%s,%s,%s", StrictLegacy, MediumLegacy, NoneLegacy, UTF8Legacy, UTF8Legacy+1))
+	assert.Equal(t, "StrictM20,MediumM20,NoneM20,UTF8M20,ValidationLevelM20(4)",
+		fmt.Sprintf("%s,%s,%s,%s,%s", StrictM20, MediumM20, NoneM20, UTF8M20, UTF8M20+1))
+	// the values the levels had before the UTF-8 ones were added are kept
+	assert.Equal(t, []int{0, 1, 2}, []int{int(StrictLegacy), int(MediumLegacy), int(NoneLegacy)})
+	assert.Equal(t, []int{0, 1, 2}, []int{int(StrictM20), int(MediumM20), int(NoneM20)})
+}
+
+// StrictM20 is the strictest level, so it rejects everything UTF8M20 rejects
+func TestValidateStrictM20(t *testing.T) {
+	for _, in := range []string{
+		"what=z\xbdrich.unit=B.mtype=gauge",
+		"what=z\x00rich.unit=B.mtype=gauge",
+		"what=z\nrich.unit=B.mtype=gauge",
+		"what=zürich.unit=B",
+		"what=rx.unit=B.mtype=gauge_is_x",
+		"what_is_z\x1brich.unit_is_B.mtype_is_gauge",
+		"what_is_z\xbdrich.unit_is_B.mtype_is_gauge",
+	} {
+		for _, validate := range []func(string, ValidationLevelM20) error{ValidateKeyM20, ValidateKeyM20NoEquals} {
+			if validate(in, UTF8M20) != nil && validate(in, StrictM20) == nil {
+				t.Fatalf("%q: rejected by UTF8M20, but not by StrictM20", in)
+			}
+		}
+		for _, validate := range []func([]byte, ValidationLevelM20) error{ValidateKeyM20B, ValidateKeyM20NoEqualsB} {
+			if validate([]byte(in), UTF8M20) != nil && validate([]byte(in), StrictM20) == nil {
+				t.Fatalf("%q: rejected by UTF8M20, but not by StrictM20", in)
+			}
+		}
+	}
+	assert.Equal(t, "null byte at position 6", ValidateKeyM20("what=z\x00rich.unit=B.mtype=gauge", StrictM20).Error())
+}
+
 func TestValidateTagAppendixB(t *testing.T) {
 	cases := []struct {
 		in    string
@@ -208,3 +271,12 @@ func BenchmarkValidatePacketStrict(b *testing.B) {
 		}
 	}
 }
+func BenchmarkValidatePacketUTF8(b *testing.B) {
+	in := []byte("carbon.agents.foo.cache.overflow;city=zürich 123.456 1234567890")
+	for i := 0; i < b.N; i++ {
+		_, _, _, err := ValidatePacket(in, UTF8Legacy, NoneM20)
+		if err != nil {
+			panic(err)
+		}
+	}
+}
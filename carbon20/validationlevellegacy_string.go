@@ -4,9 +4,9 @@ package carbon20
 
 import "strconv"
 
-const _ValidationLevelLegacy_name = "StrictLegacyMediumLegacyNoneLegacy"
+const _ValidationLevelLegacy_name = "StrictLegacyMediumLegacyNoneLegacyUTF8Legacy"
 
-var _ValidationLevelLegacy_index = [...]uint8{0, 12, 24, 34}
+var _ValidationLevelLegacy_index = [...]uint8{0, 12, 24, 34, 44}
 
 func (i ValidationLevelLegacy) String() string {
 	if i < 0 || i >= ValidationLevelLegacy(len(_ValidationLevelLegacy_index)-1) {
@@ -4,9 +4,9 @@ package carbon20
 
 import "strconv"
 
-const _ValidationLevelM20_name = "StrictM20MediumM20NoneM20"
+const _ValidationLevelM20_name = "StrictM20MediumM20NoneM20UTF8M20"
 
-var _ValidationLevelM20_index = [...]uint8{0, 9, 18, 25}
+var _ValidationLevelM20_index = [...]uint8{0, 9, 18, 25, 32}
 
 func (i ValidationLevelM20) String() string {
 	if i < 0 || i >= ValidationLevelM20(len(_ValidationLevelM20_index)-1) {
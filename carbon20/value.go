@@ -0,0 +1,174 @@
+package carbon20
+
+import (
+	"errors"
+	"math"
+	"strconv"
+)
+
+// ErrValNotFinite is returned for NaN and infinite values that a ValuePolicy rejects
+var ErrValNotFinite = errors.New("value is NaN or infinite")
+
+// NonFinite decides what a ValuePolicy does with NaN and infinite values
+type NonFinite int
+
+const (
+	NonFinitePass     NonFinite = iota // keep the value
+	NonFiniteReject                    // return ErrValNotFinite
+	NonFiniteSentinel                  // replace the value with the sentinel
+)
+
+// ValuePolicy decides which values are acceptable. The zero value accepts all values.
+type ValuePolicy struct {
+	NonFinite NonFinite
+	Sentinel  float64 // replacement for NaN and infinite values, with NonFiniteSentinel
+}
+
+// Check checks v against the policy, and returns the value to use
+func (vp ValuePolicy) Check(v Value) (Value, error) {
+	if v.kind != ValueFloat || !(math.IsNaN(v.f) || math.IsInf(v.f, 0)) {
+		return v, nil
+	}
+	switch vp.NonFinite {
+	case NonFiniteReject:
+		return Value{}, ErrValNotFinite
+	case NonFiniteSentinel:
+		return FloatValue(vp.Sentinel), nil
+	}
+	return v, nil
+}
+
+// ValueKind is the representation of a Value
+type ValueKind int
+
+const (
+	ValueFloat ValueKind = iota // float64
+	ValueInt                    // int64
+	ValueUint                   // uint64, only used for integers above math.MaxInt64
+)
+
+// Value is the value of a point. Integers are kept exactly, so that large counters
+// (above 2^53) don't lose precision, other values are kept as float64.
+// The zero value is the float 0.
+type Value struct {
+	kind ValueKind
+	i    int64
+	u    uint64
+	f    float64
+}
+
+// FloatValue returns a Value holding f
+func FloatValue(f float64) Value {
+	return Value{kind: ValueFloat, f: f}
+}
+
+// IntValue returns a Value holding i
+func IntValue(i int64) Value {
+	return Value{kind: ValueInt, i: i}
+}
+
+// UintValue returns a Value holding u. if u fits in an int64, it is stored as one
+func UintValue(u uint64) Value {
+	if u <= math.MaxInt64 {
+		return IntValue(int64(u))
+	}
+	return Value{kind: ValueUint, u: u}
+}
+
+// ParseValue parses the value field of a carbon message.
+// integers that fit in an int64 or uint64 are kept exactly, anything else is parsed with strconv.ParseFloat.
+func ParseValue(buf []byte) (Value, error) {
+	if isInteger(buf) {
+		if buf[0] == '-' {
+			i, err := strconv.ParseInt(string(buf), 10, 64)
+			if err == nil {
+				return IntValue(i), nil
+			}
+		} else {
+			u, err := strconv.ParseUint(string(trimPlus(buf)), 10, 64)
+			if err == nil {
+				return UintValue(u), nil
+			}
+		}
+	}
+	f, err := strconv.ParseFloat(string(buf), 64)
+	if err != nil {
+		return Value{}, errValNotNumber
+	}
+	return FloatValue(f), nil
+}
+
+// isInteger returns whether buf is an optionally signed sequence of decimal digits
+func isInteger(buf []byte) bool {
+	buf = trimPlus(buf)
+	if len(buf) != 0 && buf[0] == '-' {
+		buf = buf[1:]
+	}
+	if len(buf) == 0 {
+		return false
+	}
+	for _, c := range buf {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func trimPlus(buf []byte) []byte {
+	if len(buf) != 0 && buf[0] == '+' {
+		return buf[1:]
+	}
+	return buf
+}
+
+// Kind returns how the value is represented
+func (v Value) Kind() ValueKind {
+	return v.kind
+}
+
+// Float64 returns the value as a float64, which may lose precision for large integers
+func (v Value) Float64() float64 {
+	switch v.kind {
+	case ValueInt:
+		return float64(v.i)
+	case ValueUint:
+		return float64(v.u)
+	}
+	return v.f
+}
+
+// Int64 returns the value as an int64. ok is false if it is not an integer that fits in an int64
+func (v Value) Int64() (i int64, ok bool) {
+	return v.i, v.kind == ValueInt
+}
+
+// Uint64 returns the value as a uint64. ok is false if it is not a non-negative integer
+func (v Value) Uint64() (u uint64, ok bool) {
+	switch v.kind {
+	case ValueInt:
+		if v.i < 0 {
+			return 0, false
+		}
+		return uint64(v.i), true
+	case ValueUint:
+		return v.u, true
+	}
+	return 0, false
+}
+
+// AppendTo appends the value, formatted as in a carbon message, to dst
+func (v Value) AppendTo(dst []byte) []byte {
+	switch v.kind {
+	case ValueInt:
+		return strconv.AppendInt(dst, v.i, 10)
+	case ValueUint:
+		return strconv.AppendUint(dst, v.u, 10)
+	}
+	return strconv.AppendFloat(dst, v.f, 'f', -1, 64)
+}
+
+// String returns the value formatted as in a carbon message
+func (v Value) String() string {
+	return string(v.AppendTo(nil))
+}
@@ -0,0 +1,110 @@
+package carbon20
+
+import (
+	"math"
+	"testing"
+
+	"github.com/bmizerany/assert"
+)
+
+func TestParseValue(t *testing.T) {
+	cases := []struct {
+		in   string
+		kind ValueKind
+		out  string
+	}{
+		{"0", ValueInt, "0"},
+		{"-1", ValueInt, "-1"},
+		{"+42", ValueInt, "42"},
+		{"007", ValueInt, "7"},
+		{"9007199254740993", ValueInt, "9007199254740993"}, // 2^53+1, not representable as float64
+		{"9223372036854775807", ValueInt, "9223372036854775807"},
+		{"18446744073709551615", ValueUint, "18446744073709551615"},
+		{"18446744073709551616", ValueFloat, "18446744073709552000"},
+		{"-9223372036854775809", ValueFloat, "-9223372036854776000"},
+		{"1.5", ValueFloat, "1.5"},
+		{"1e5", ValueFloat, "100000"},
+		{"-0.25", ValueFloat, "-0.25"},
+	}
+	for _, c := range cases {
+		v, err := ParseValue([]byte(c.in))
+		if err != nil {
+			t.Fatalf("ParseValue(%q): %s", c.in, err)
+		}
+		assert.Equal(t, c.kind, v.Kind())
+		assert.Equal(t, c.out, v.String())
+	}
+	for _, in := range []string{"", "+", "-", "++1", "z1", "0x10"} {
+		_, err := ParseValue([]byte(in))
+		assert.Equal(t, errValNotNumber, err)
+	}
+}
+
+func TestValueConversions(t *testing.T) {
+	v := UintValue(math.MaxUint64)
+	u, ok := v.Uint64()
+	assert.Equal(t, uint64(math.MaxUint64), u)
+	assert.Equal(t, true, ok)
+	_, ok = v.Int64()
+	assert.Equal(t, false, ok)
+
+	v = UintValue(5)
+	assert.Equal(t, ValueInt, v.Kind())
+	i, ok := v.Int64()
+	assert.Equal(t, int64(5), i)
+	assert.Equal(t, true, ok)
+
+	_, ok = IntValue(-5).Uint64()
+	assert.Equal(t, false, ok)
+	_, ok = FloatValue(5).Int64()
+	assert.Equal(t, false, ok)
+	assert.Equal(t, float64(-5), IntValue(-5).Float64())
+	assert.Equal(t, 0.0, Value{}.Float64())
+}
+
+func TestValuePolicy(t *testing.T) {
+	reject := ValuePolicy{NonFinite: NonFiniteReject}
+	sentinel := ValuePolicy{NonFinite: NonFiniteSentinel, Sentinel: -1}
+	rejectParser, err := NewPacketParser(PacketOptions{LevelLegacy: MediumLegacy, LevelM20: MediumM20, Value: reject})
+	assert.Equal(t, nil, err)
+	sentinelParser, err := NewPacketParser(PacketOptions{LevelLegacy: MediumLegacy, LevelM20: MediumM20, Value: sentinel})
+	assert.Equal(t, nil, err)
+	for _, in := range []string{"NaN", "nan", "inf", "+Inf", "-Infinity"} {
+		v, err := ParseValue([]byte(in))
+		assert.Equal(t, nil, err)
+
+		out, err := ValuePolicy{}.Check(v)
+		assert.Equal(t, nil, err)
+		assert.Equal(t, v.Float64() != v.Float64(), out.Float64() != out.Float64())
+
+		_, err = reject.Check(v)
+		assert.Equal(t, ErrValNotFinite, err)
+
+		out, err = sentinel.Check(v)
+		assert.Equal(t, nil, err)
+		assert.Equal(t, FloatValue(-1), out)
+
+		packet := "foo.bar " + in + " 1234567890"
+		_, err = rejectParser.Parse([]byte(packet))
+		assert.Equal(t, ErrValNotFinite, err)
+		pkt, err := sentinelParser.Parse([]byte(packet))
+		assert.Equal(t, nil, err)
+		assert.Equal(t, FloatValue(-1), pkt.Value)
+
+		// ValidatePacket keeps passing them through
+		_, _, _, err = ValidatePacket([]byte(packet), MediumLegacy, MediumM20)
+		assert.Equal(t, nil, err)
+
+		buf, err := AppendPacket([]byte("x"), []byte("foo.bar"), v, 1, PrecisionSecond, reject)
+		assert.Equal(t, ErrValNotFinite, err)
+		assert.Equal(t, "x", string(buf))
+		buf, err = AppendPacket(nil, []byte("foo.bar"), v, 1, PrecisionSecond, sentinel)
+		assert.Equal(t, nil, err)
+		assert.Equal(t, "foo.bar -1 1\n", string(buf))
+	}
+	for _, v := range []Value{IntValue(-3), UintValue(math.MaxUint64), FloatValue(1.5)} {
+		out, err := reject.Check(v)
+		assert.Equal(t, nil, err)
+		assert.Equal(t, v, out)
+	}
+}